@@ -0,0 +1,220 @@
+package trace_errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Field keys used by BatchResult.
+const (
+	// ItemField holds the identifier of the item a batch failure is about.
+	ItemField = "item"
+	// HTTPStatusField holds the HTTP status reported for a failed item by
+	// BatchResult.WriteHTTP. Failures without it are reported as 500.
+	HTTPStatusField = "http_status"
+)
+
+// BatchStatus is the overall outcome of a bulk operation.
+type BatchStatus int
+
+const (
+	// BatchSucceeded means no item failed, including when there were none.
+	BatchSucceeded BatchStatus = iota
+	// BatchPartial means some items failed and some succeeded.
+	BatchPartial
+	// BatchFailed means every item failed.
+	BatchFailed
+)
+
+// String returns the name of the status.
+func (s BatchStatus) String() string {
+	switch s {
+	case BatchSucceeded:
+		return "succeeded"
+	case BatchPartial:
+		return "partial"
+	case BatchFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("BatchStatus(%d)", int(s))
+}
+
+// BatchItem is the outcome of one item of a bulk operation.
+type BatchItem struct {
+	ID  string
+	Err error // nil if the item succeeded
+}
+
+// BatchResult collects the outcome of a bulk operation item by item.
+// Items are identified by an ID; use strconv.Itoa to key them by index.
+//
+// The zero value is ready to use and a BatchResult is safe for
+// concurrent use.
+type BatchResult struct {
+	mu    sync.Mutex
+	items []BatchItem
+}
+
+// Succeed records that the item id succeeded.
+func (r *BatchResult) Succeed(id string) {
+	r.mu.Lock()
+	r.items = append(r.items, BatchItem{ID: id})
+	r.mu.Unlock()
+}
+
+// Fail records that the item id failed with err. The error is wrapped in a
+// TraceError carrying the caller's frame and the item id in ItemField.
+// A nil err records a success.
+func (r *BatchResult) Fail(id string, err error) {
+	if err != nil {
+		err = created(&TraceError{
+			Err:    err,
+			Frame:  captureStackFrame(),
+			Fields: map[string]interface{}{ItemField: id},
+		})
+	}
+	r.mu.Lock()
+	r.items = append(r.items, BatchItem{ID: id, Err: err})
+	r.mu.Unlock()
+}
+
+// Items returns the recorded items in the order they were recorded.
+func (r *BatchResult) Items() []BatchItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]BatchItem(nil), r.items...)
+}
+
+// Failures returns the failed items in the order they were recorded.
+func (r *BatchResult) Failures() []BatchItem {
+	return batchFailures(r.Items())
+}
+
+func batchFailures(items []BatchItem) []BatchItem {
+	var failures []BatchItem
+	for _, item := range items {
+		if item.Err != nil {
+			failures = append(failures, item)
+		}
+	}
+	return failures
+}
+
+// Status returns the overall outcome.
+func (r *BatchResult) Status() BatchStatus {
+	return batchStatus(r.Items())
+}
+
+func batchStatus(items []BatchItem) BatchStatus {
+	failed := len(batchFailures(items))
+	switch {
+	case failed == 0:
+		return BatchSucceeded
+	case failed == len(items):
+		return BatchFailed
+	}
+	return BatchPartial
+}
+
+// Err returns nil if no item failed and a *PartialError otherwise.
+func (r *BatchResult) Err() error {
+	items := r.Items()
+	failures := batchFailures(items)
+	if len(failures) == 0 {
+		return nil
+	}
+	return &PartialError{Total: len(items), Failures: failures}
+}
+
+// WriteHTTP writes the result as an HTTP response: 200 when every item
+// succeeded and 207 Multi-Status otherwise, with a JSON body listing the
+// status of every item and the error chain of every failure.
+func (r *BatchResult) WriteHTTP(w http.ResponseWriter, opts RenderOptions) error {
+	type itemJSON struct {
+		ID     string     `json:"id"`
+		Status int        `json:"status"`
+		Error  *jsonError `json:"error,omitempty"`
+	}
+	body := struct {
+		Status string     `json:"status"`
+		Items  []itemJSON `json:"items"`
+	}{Items: []itemJSON{}}
+	items := r.Items()
+	status := batchStatus(items)
+	body.Status = status.String()
+	for _, item := range items {
+		ij := itemJSON{ID: item.ID, Status: http.StatusOK}
+		if item.Err != nil {
+			ij.Status = itemHTTPStatus(item.Err)
+			je := toJSON(item.Err, opts)
+			ij.Error = &je
+		}
+		body.Items = append(body.Items, ij)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status == BatchSucceeded {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusMultiStatus)
+	}
+	return json.NewEncoder(w).Encode(body)
+}
+
+func itemHTTPStatus(err error) int {
+	switch v := FieldsOf(err)[HTTPStatusField].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return http.StatusInternalServerError
+}
+
+// PartialError reports the failed items of a bulk operation. It unwraps to
+// the errors of the failed items, like the result of errors.Join.
+type PartialError struct {
+	Total    int
+	Failures []BatchItem
+}
+
+// Error implements the error interface, listing the failed items.
+func (e *PartialError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d items failed", len(e.Failures), e.Total)
+	for _, item := range e.Failures {
+		fmt.Fprintf(&b, "\n%s: %s", item.ID, message(item.Err))
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the failed items.
+func (e *PartialError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, item := range e.Failures {
+		errs[i] = item.Err
+	}
+	return errs
+}
+
+// MarshalJSON writes the failed items with their error chains.
+func (e *PartialError) MarshalJSON() ([]byte, error) {
+	type itemJSON struct {
+		ID    string    `json:"id"`
+		Error jsonError `json:"error"`
+	}
+	out := struct {
+		Total  int        `json:"total"`
+		Failed int        `json:"failed"`
+		Items  []itemJSON `json:"items"`
+	}{Total: e.Total, Failed: len(e.Failures), Items: []itemJSON{}}
+	for _, item := range e.Failures {
+		out.Items = append(out.Items, itemJSON{ID: item.ID, Error: toJSON(item.Err, RenderOptions{Stack: true})})
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,185 @@
+package trace_errors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchStatus(t *testing.T) {
+	var empty BatchResult
+	if s := empty.Status(); s != BatchSucceeded {
+		t.Errorf("empty batch: %v, want succeeded", s)
+	}
+	if err := empty.Err(); err != nil {
+		t.Errorf("empty batch: Err = %v", err)
+	}
+
+	var r BatchResult
+	r.Succeed("a")
+	r.Fail("b", nil)
+	if s := r.Status(); s != BatchSucceeded {
+		t.Errorf("no failures: %v, want succeeded", s)
+	}
+	r.Fail("c", errors.New("broken"))
+	if s := r.Status(); s != BatchPartial {
+		t.Errorf("one failure: %v, want partial", s)
+	}
+
+	var failed BatchResult
+	failed.Fail("a", errors.New("broken"))
+	if s := failed.Status(); s != BatchFailed {
+		t.Errorf("all failed: %v, want failed", s)
+	}
+	if s := BatchStatus(7).String(); s != "BatchStatus(7)" {
+		t.Errorf("unknown status prints as %q", s)
+	}
+}
+
+func TestBatchFail(t *testing.T) {
+	var r BatchResult
+	cause := errors.New("quota exceeded")
+	r.Succeed("1")
+	r.Fail("2", cause)
+
+	items := r.Items()
+	if len(items) != 2 || items[0].ID != "1" || items[1].ID != "2" || items[0].Err != nil {
+		t.Fatalf("items = %+v", items)
+	}
+	failures := r.Failures()
+	if len(failures) != 1 || failures[0].ID != "2" {
+		t.Fatalf("failures = %+v", failures)
+	}
+	te, ok := failures[0].Err.(*TraceError)
+	if !ok || te.Err != cause {
+		t.Fatalf("failure error = %#v, want a TraceError wrapping the cause", failures[0].Err)
+	}
+	if te.Fields[ItemField] != "2" {
+		t.Errorf("fields = %v, want the item id", te.Fields)
+	}
+	if !strings.Contains(te.Frame, "TestBatchFail") {
+		t.Errorf("frame %q is not the caller's", te.Frame)
+	}
+}
+
+func TestPartialError(t *testing.T) {
+	var r BatchResult
+	first, second := errors.New("first"), errors.New("second")
+	r.Fail("a", first)
+	r.Succeed("b")
+	r.Fail("c", Wrap(second, "wrapped"))
+
+	err := r.Err()
+	var pe *PartialError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Err = %#v, want a *PartialError", err)
+	}
+	if pe.Total != 3 || len(pe.Failures) != 2 {
+		t.Errorf("PartialError = %+v", pe)
+	}
+	if want := "2 of 3 items failed\na: first\nc: wrapped: second"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if !errors.Is(err, first) || !errors.Is(err, second) {
+		t.Error("PartialError does not unwrap to the item errors")
+	}
+
+	data, merr := json.Marshal(err)
+	if merr != nil {
+		t.Fatal(merr)
+	}
+	var out struct {
+		Total  int `json:"total"`
+		Failed int `json:"failed"`
+		Items  []struct {
+			ID    string `json:"id"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Total != 3 || out.Failed != 2 || len(out.Items) != 2 ||
+		out.Items[1].ID != "c" || out.Items[1].Error.Message != "wrapped: second" {
+		t.Errorf("JSON = %s", data)
+	}
+}
+
+type batchBody struct {
+	Status string `json:"status"`
+	Items  []struct {
+		ID     string     `json:"id"`
+		Status int        `json:"status"`
+		Error  *jsonError `json:"error"`
+	} `json:"items"`
+}
+
+func writeBatch(t *testing.T, r *BatchResult) (*httptest.ResponseRecorder, batchBody) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if err := r.WriteHTTP(rec, RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var body batchBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q: %v", rec.Body.String(), err)
+	}
+	return rec, body
+}
+
+func TestBatchWriteHTTP(t *testing.T) {
+	var r BatchResult
+	r.Succeed("ok")
+	r.Fail("missing", WithFields(errors.New("no such user"), Field{Key: HTTPStatusField, Value: http.StatusNotFound}))
+	r.Fail("broken", errors.New("database down"))
+
+	rec, body := writeBatch(t, &r)
+	if rec.Code != http.StatusMultiStatus {
+		t.Errorf("status = %d, want 207", rec.Code)
+	}
+	if body.Status != "partial" || len(body.Items) != 3 {
+		t.Fatalf("body = %s", rec.Body.String())
+	}
+	want := []struct {
+		id     string
+		status int
+		msg    string
+	}{
+		{"ok", http.StatusOK, ""},
+		{"missing", http.StatusNotFound, "no such user"},
+		{"broken", http.StatusInternalServerError, "database down"},
+	}
+	for i, w := range want {
+		item := body.Items[i]
+		if item.ID != w.id || item.Status != w.status {
+			t.Errorf("item %d = %s %d, want %s %d", i, item.ID, item.Status, w.id, w.status)
+		}
+		switch {
+		case w.msg == "" && item.Error != nil:
+			t.Errorf("item %s has an error: %+v", w.id, item.Error)
+		case w.msg != "" && (item.Error == nil || item.Error.Message != w.msg):
+			t.Errorf("item %s error = %+v, want %q", w.id, item.Error, w.msg)
+		}
+	}
+
+	var all BatchResult
+	all.Succeed("a")
+	rec, body = writeBatch(t, &all)
+	if rec.Code != http.StatusOK || body.Status != "succeeded" {
+		t.Errorf("all succeeded: %d %s", rec.Code, rec.Body.String())
+	}
+
+	var empty BatchResult
+	rec, _ = writeBatch(t, &empty)
+	if !strings.Contains(rec.Body.String(), `"items":[]`) {
+		t.Errorf("empty batch body %q does not list an empty items array", rec.Body.String())
+	}
+}
@@ -0,0 +1,194 @@
+package trace_errors
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// CloudEventType is the type attribute of the CloudEvents made from errors.
+const CloudEventType = "dev.trace_errors.error"
+
+// cloudEventsMediaType is the content type of a structured-mode event.
+const cloudEventsMediaType = "application/cloudevents+json"
+
+// CloudEventSource is the source attribute of the CloudEvents made from
+// errors. It defaults to the main module path of the program, or to the
+// program name when no build information is available.
+var CloudEventSource = defaultCloudEventSource()
+
+func defaultCloudEventSource() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Path != "" {
+		return info.Main.Path
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// CloudEvent is a CloudEvents 1.0 event carrying a serialized error chain,
+// as written by MarshalChain, in its data.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Fingerprint     string          `json:"fingerprint,omitempty"` // extension
+	ErrorCode       string          `json:"errorcode,omitempty"`   // extension
+	Data            json.RawMessage `json:"data"`
+}
+
+// NewCloudEvent packages the chain of err as a CloudEvent. It fails if err
+// is nil.
+func NewCloudEvent(err error) (*CloudEvent, error) {
+	if err == nil {
+		return nil, errors.New("trace_errors: CloudEvent of a nil error")
+	}
+	data, merr := MarshalChain(err)
+	if merr != nil {
+		return nil, merr
+	}
+	var id [16]byte
+	if _, rerr := rand.Read(id[:]); rerr != nil {
+		return nil, rerr
+	}
+	return &CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(id[:]),
+		Source:          CloudEventSource,
+		Type:            CloudEventType,
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Fingerprint:     Fingerprint(err),
+		ErrorCode:       Code(err),
+		Data:            data,
+	}, nil
+}
+
+// Cause rebuilds the error chain carried by the event.
+func (ev *CloudEvent) Cause() (error, error) {
+	return UnmarshalChain(ev.Data)
+}
+
+// MarshalCloudEvent packages the chain of err as a structured-mode
+// CloudEvent in JSON.
+func MarshalCloudEvent(err error) ([]byte, error) {
+	ev, nerr := NewCloudEvent(err)
+	if nerr != nil {
+		return nil, nerr
+	}
+	return json.Marshal(ev)
+}
+
+// UnmarshalCloudEvent decodes a structured-mode CloudEvent made by
+// MarshalCloudEvent.
+func UnmarshalCloudEvent(data []byte) (*CloudEvent, error) {
+	var ev CloudEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return nil, err
+	}
+	if err := ev.validate(); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func (ev *CloudEvent) validate() error {
+	if ev.SpecVersion != "1.0" {
+		return fmt.Errorf("trace_errors: unsupported CloudEvents specversion %q", ev.SpecVersion)
+	}
+	if ev.Type != CloudEventType {
+		return fmt.Errorf("trace_errors: CloudEvent type %q is not %q", ev.Type, CloudEventType)
+	}
+	if ev.ID == "" || ev.Source == "" {
+		return errors.New("trace_errors: CloudEvent lacks id or source")
+	}
+	return nil
+}
+
+// WriteHTTP sets the headers of a binary-mode CloudEvents HTTP message in h
+// and returns the body to send with them. Attribute values are
+// percent-encoded as the HTTP binding requires.
+func (ev *CloudEvent) WriteHTTP(h http.Header) []byte {
+	set := func(name, value string) {
+		if value != "" {
+			h.Set(name, ceHeaderEncode(value))
+		}
+	}
+	set("ce-specversion", ev.SpecVersion)
+	set("ce-id", ev.ID)
+	set("ce-source", ev.Source)
+	set("ce-type", ev.Type)
+	set("ce-time", ev.Time)
+	set("ce-fingerprint", ev.Fingerprint)
+	set("ce-errorcode", ev.ErrorCode)
+	contentType := ev.DataContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	h.Set("Content-Type", contentType)
+	return ev.Data
+}
+
+// ReadCloudEventHTTP decodes a CloudEvent from an HTTP message in either
+// binary or structured mode.
+func ReadCloudEventHTTP(h http.Header, body []byte) (*CloudEvent, error) {
+	if mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type")); err == nil && mediaType == cloudEventsMediaType {
+		return UnmarshalCloudEvent(body)
+	}
+	var derr error
+	get := func(name string) string {
+		value, err := url.PathUnescape(h.Get(name))
+		if err != nil && derr == nil {
+			derr = fmt.Errorf("trace_errors: CloudEvent header %s: %w", name, err)
+		}
+		return value
+	}
+	ev := &CloudEvent{
+		SpecVersion:     get("ce-specversion"),
+		ID:              get("ce-id"),
+		Source:          get("ce-source"),
+		Type:            get("ce-type"),
+		Time:            get("ce-time"),
+		DataContentType: h.Get("Content-Type"),
+		Fingerprint:     get("ce-fingerprint"),
+		ErrorCode:       get("ce-errorcode"),
+		Data:            json.RawMessage(body),
+	}
+	if derr != nil {
+		return nil, derr
+	}
+	if err := ev.validate(); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// ceHeaderEncode percent-encodes the UTF-8 bytes of s that the CloudEvents
+// HTTP binding does not allow in a header value: space, '"', '%' and
+// everything outside printable ASCII.
+func ceHeaderEncode(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '%' {
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xf])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,129 @@
+package trace_errors
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCloudEventStructured(t *testing.T) {
+	err := WithCode(Wrap(New("row locked"), "saving order"), "E_LOCK")
+	data, merr := MarshalCloudEvent(err)
+	if merr != nil {
+		t.Fatal(merr)
+	}
+	ev, uerr := UnmarshalCloudEvent(data)
+	if uerr != nil {
+		t.Fatal(uerr)
+	}
+	if ev.SpecVersion != "1.0" || ev.Type != CloudEventType || ev.Source != CloudEventSource || ev.ID == "" {
+		t.Errorf("event attributes = %+v", ev)
+	}
+	if ev.Fingerprint != Fingerprint(err) || ev.ErrorCode != "E_LOCK" {
+		t.Errorf("extensions = %q, %q", ev.Fingerprint, ev.ErrorCode)
+	}
+	cause, cerr := ev.Cause()
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	if cause.Error() != err.Error() {
+		t.Errorf("Cause() = %q, want %q", cause.Error(), err.Error())
+	}
+
+	h := http.Header{"Content-Type": {cloudEventsMediaType + "; charset=utf-8"}}
+	read, rerr := ReadCloudEventHTTP(h, data)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if !reflect.DeepEqual(read, ev) {
+		t.Errorf("structured HTTP read %+v, want %+v", read, ev)
+	}
+}
+
+func TestCloudEventBinary(t *testing.T) {
+	err := WithCode(New("failed"), `tenant "acmé" 100%`)
+	ev, nerr := NewCloudEvent(err)
+	if nerr != nil {
+		t.Fatal(nerr)
+	}
+	h := http.Header{}
+	body := ev.WriteHTTP(h)
+	if got := h.Get("ce-errorcode"); got != "tenant%20%22acm%C3%A9%22%20100%25" {
+		t.Errorf("ce-errorcode = %q, want it percent-encoded", got)
+	}
+	if h.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q", h.Get("Content-Type"))
+	}
+
+	read, rerr := ReadCloudEventHTTP(h, body)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if !reflect.DeepEqual(read, ev) {
+		t.Errorf("binary round trip gave %+v, want %+v", read, ev)
+	}
+	cause, cerr := read.Cause()
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	if Code(cause) != `tenant "acmé" 100%` {
+		t.Errorf("code = %q", Code(cause))
+	}
+}
+
+func TestCloudEventErrors(t *testing.T) {
+	if ev, err := NewCloudEvent(nil); err == nil || ev != nil {
+		t.Errorf("NewCloudEvent(nil) = %v, %v; want an error", ev, err)
+	}
+	if _, err := MarshalCloudEvent(nil); err == nil {
+		t.Error("MarshalCloudEvent(nil) succeeded")
+	}
+
+	valid, err := NewCloudEvent(New("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify := func(f func(ev *CloudEvent)) []byte {
+		ev := *valid
+		f(&ev)
+		data, err := json.Marshal(&ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	for name, data := range map[string][]byte{
+		"malformed":   []byte("{"),
+		"specversion": modify(func(ev *CloudEvent) { ev.SpecVersion = "0.3" }),
+		"type":        modify(func(ev *CloudEvent) { ev.Type = "com.example.other" }),
+		"no id":       modify(func(ev *CloudEvent) { ev.ID = "" }),
+	} {
+		if ev, err := UnmarshalCloudEvent(data); err == nil || ev != nil {
+			t.Errorf("%s: UnmarshalCloudEvent = %v, %v; want a nil event and an error", name, ev, err)
+		}
+	}
+
+	for name, f := range map[string]func(h http.Header){
+		"no source": func(h http.Header) { h.Del("ce-source") },
+		"type":      func(h http.Header) { h.Set("ce-type", "other") },
+		"escape":    func(h http.Header) { h.Set("ce-id", "bad%zz") },
+	} {
+		h := http.Header{}
+		body := valid.WriteHTTP(h)
+		f(h)
+		ev, err := ReadCloudEventHTTP(h, body)
+		if err == nil || ev != nil {
+			t.Errorf("%s: ReadCloudEventHTTP = %v, %v; want a nil event and an error", name, ev, err)
+		}
+		if name == "escape" && (err == nil || !strings.Contains(err.Error(), "ce-id")) {
+			t.Errorf("escape: error %v does not name the header", err)
+		}
+	}
+
+	h := http.Header{"Content-Type": {cloudEventsMediaType}}
+	if ev, err := ReadCloudEventHTTP(h, []byte("not json")); err == nil || ev != nil {
+		t.Errorf("structured malformed: %v, %v", ev, err)
+	}
+}
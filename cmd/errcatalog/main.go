@@ -0,0 +1,306 @@
+// Command errcatalog lists the sites in a Go module that create or wrap
+// errors with trace_errors, together with their site codes.
+//
+// Usage:
+//
+//	errcatalog [-json] [-tests] [dir]
+//
+// It parses the source under dir (the current directory by default) and
+// computes, for each call to a trace_errors constructor, the code that
+// trace_errors.SiteCode reports at run time. Calls whose message is not a
+// string constant have no stable code and are listed with "-".
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	te "github.com/apepenkov/trace_errors"
+)
+
+const importPath = "github.com/apepenkov/trace_errors"
+
+// templateArg maps each constructor to the index of its message argument,
+// or -1 for constructors without a message.
+var templateArg = map[string]int{
+	"New": 0, "Newf": 0, "Wrap": 1, "Wrapf": 1,
+	"NewCtx": 1, "NewfCtx": 1, "WrapCtx": 2, "WrapfCtx": 2,
+	"WrapTrace": -1, "WrapTraceCtx": -1, "WithCode": -1, "WithFields": -1,
+}
+
+// Site is one entry of the catalog.
+type Site struct {
+	Code     string `json:"code,omitempty"`
+	Package  string `json:"package"`
+	Function string `json:"function"`
+	Template string `json:"template"`
+	Dynamic  bool   `json:"dynamic,omitempty"`
+	Position string `json:"position"`
+
+	file string
+	line int
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the catalog as JSON")
+	tests := flag.Bool("tests", false, "include _test.go files")
+	flag.Parse()
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	sites, err := catalog(dir, *tests)
+	if err == nil {
+		err = write(os.Stdout, sites, *asJSON)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "errcatalog: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// write prints sites to w as a table, or as JSON if asJSON is set.
+func write(w io.Writer, sites []Site, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sites)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tSITE\tTEMPLATE\tPOSITION")
+	for _, s := range sites {
+		code, template := s.Code, strconv.Quote(s.Template)
+		if s.Dynamic {
+			code, template = "-", "(dynamic)"
+		}
+		fmt.Fprintf(tw, "%s\t%s.%s\t%s\t%s\n", code, s.Package, s.Function, template, s.Position)
+	}
+	return tw.Flush()
+}
+
+// catalog parses the packages under dir and returns their sites.
+func catalog(dir string, tests bool) ([]Site, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, module, err := findModule(dir)
+	if err != nil {
+		return nil, err
+	}
+	var sites []Site
+	fset := token.NewFileSet()
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if p != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(name, ".go") || (!tests && strings.HasSuffix(name, "_test.go")) {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, p, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		pkg := packagePath(module, filepath.ToSlash(rel), f.Name.Name)
+		display, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sites = append(sites, fileSites(fset, f, pkg, display)...)
+		return nil
+	})
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].Package != sites[j].Package {
+			return sites[i].Package < sites[j].Package
+		}
+		if sites[i].file != sites[j].file {
+			return sites[i].file < sites[j].file
+		}
+		return sites[i].line < sites[j].line
+	})
+	return sites, err
+}
+
+// findModule returns the directory holding the go.mod above the absolute
+// path dir and the module path it declares.
+func findModule(dir string) (root, module string, err error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		data, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "module" {
+					return d, strings.Trim(fields[1], `"`), nil
+				}
+			}
+			return "", "", fmt.Errorf("%s: no module directive", filepath.Join(d, "go.mod"))
+		}
+		if filepath.Dir(d) == d {
+			return "", "", errors.New("no go.mod found above " + dir)
+		}
+	}
+}
+
+// packagePath returns the package path as it appears in runtime function
+// names: "main" for commands, and dots in the last element escaped.
+func packagePath(module, rel, name string) string {
+	if name == "main" {
+		return "main"
+	}
+	p := module
+	if rel != "." {
+		p = path.Join(module, rel)
+	}
+	dir, last := path.Split(p)
+	return dir + strings.ReplaceAll(last, ".", "%2e")
+}
+
+// fileSites returns the sites of one file, reporting their position in
+// the file named display.
+func fileSites(fset *token.FileSet, f *ast.File, pkg, display string) []Site {
+	local := ""
+	for _, imp := range f.Imports {
+		if p, _ := strconv.Unquote(imp.Path.Value); p == importPath {
+			local = "trace_errors"
+			if imp.Name != nil {
+				local = imp.Name.Name
+			}
+		}
+	}
+	if local == "" || local == "_" || local == "." {
+		return nil
+	}
+
+	var sites []Site
+	for _, decl := range f.Decls {
+		function := "init"
+		if fd, ok := decl.(*ast.FuncDecl); ok {
+			function = funcName(fd)
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			x, ok := sel.X.(*ast.Ident)
+			if !ok || x.Name != local {
+				return true
+			}
+			arg, ok := templateArg[sel.Sel.Name]
+			if !ok {
+				return true
+			}
+			pos := fset.Position(call.Pos())
+			s := Site{
+				Package:  pkg,
+				Function: function,
+				Position: fmt.Sprintf("%s:%d:%d", display, pos.Line, pos.Column),
+				file:     display,
+				line:     pos.Line,
+			}
+			if arg >= 0 {
+				if arg < len(call.Args) {
+					s.Template, ok = constString(call.Args[arg])
+				}
+				s.Dynamic = !ok
+			}
+			if !s.Dynamic {
+				s.Code = te.ComputeSiteCode(pkg, function, s.Template)
+			}
+			sites = append(sites, s)
+			return true
+		})
+	}
+	return sites
+}
+
+// funcName returns the name the runtime gives to the function declared by
+// fd, without its package.
+func funcName(fd *ast.FuncDecl) string {
+	name := fd.Name.Name
+	if fd.Type.TypeParams != nil {
+		name += "[...]"
+	}
+	if fd.Recv == nil || len(fd.Recv.List) == 0 {
+		return name
+	}
+	typ := fd.Recv.List[0].Type
+	star := false
+	if s, ok := typ.(*ast.StarExpr); ok {
+		typ, star = s.X, true
+	}
+	var recv string
+	switch t := typ.(type) {
+	case *ast.Ident:
+		recv = t.Name
+	case *ast.IndexExpr:
+		recv = exprName(t.X) + "[...]"
+	case *ast.IndexListExpr:
+		recv = exprName(t.X) + "[...]"
+	}
+	if star {
+		return "(*" + recv + ")." + name
+	}
+	return recv + "." + name
+}
+
+func exprName(e ast.Expr) string {
+	if id, ok := e.(*ast.Ident); ok {
+		return id.Name
+	}
+	return "?"
+}
+
+// constString evaluates a string literal or a concatenation of them.
+func constString(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.ParenExpr:
+		return constString(e.X)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		l, ok := constString(e.X)
+		if !ok {
+			return "", false
+		}
+		r, ok := constString(e.Y)
+		return l + r, ok
+	}
+	return "", false
+}
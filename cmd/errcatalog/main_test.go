@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	te "github.com/apepenkov/trace_errors"
+	"github.com/apepenkov/trace_errors/cmd/errcatalog/testdata/sites"
+)
+
+// testSites returns the sites errcatalog finds in testdata/sites, by
+// template.
+func testSites(t *testing.T) map[string]Site {
+	t.Helper()
+	list, err := catalog("testdata/sites", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTemplate := make(map[string]Site)
+	for _, s := range list {
+		byTemplate[s.Template] = s
+	}
+	return byTemplate
+}
+
+func TestCatalogMatchesRuntime(t *testing.T) {
+	found := testSites(t)
+	runtime := map[string]error{
+		"plain site":                   sites.Plain(),
+		"formatted site %d":            sites.Formatted(1),
+		"wrap site":                    sites.Wrapped(),
+		"closure site":                 sites.Closure(),
+		"generic site %v":              sites.Generic("x"),
+		"method site":                  new(sites.Store).Get(),
+		"generic method site %v":       (&sites.Box[int]{V: 1}).Get(),
+		"generic value method site %v": sites.Box[string]{V: "x"}.Value(),
+		"init site":                    sites.Init(),
+	}
+	for template, err := range runtime {
+		s, ok := found[template]
+		if !ok {
+			t.Errorf("%q: site not in the catalog", template)
+			continue
+		}
+		if got := te.SiteCode(err); s.Code != got {
+			t.Errorf("%q: catalog code %s (%s.%s), runtime code %s", template, s.Code, s.Package, s.Function, got)
+		}
+	}
+
+	if n := len(runtime) + 1; len(found) != n {
+		t.Errorf("found %d sites in testdata/sites, want %d", len(found), n)
+	}
+	if s := found[""]; !s.Dynamic || s.Code != "" || s.Function != "Dynamic" {
+		t.Errorf("dynamic site = %+v", s)
+	}
+}
+
+func TestCatalogSkips(t *testing.T) {
+	list, err := catalog(".", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range list {
+		if strings.HasSuffix(s.file, "_test.go") || strings.HasPrefix(s.file, "testdata") {
+			t.Errorf("site in a test file or testdata: %+v", s)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	found := testSites(t)
+	list := []Site{found["plain site"], found[""]}
+
+	var b bytes.Buffer
+	if err := write(&b, list, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "CODE ") {
+		t.Fatalf("table:\n%s", b.String())
+	}
+	if f := strings.Fields(lines[1]); len(f) != 5 || f[0] != list[0].Code || f[1] != list[0].Package+".Plain" || f[2] != `"plain` {
+		t.Errorf("row %q", lines[1])
+	}
+	if f := strings.Fields(lines[2]); f[0] != "-" || f[2] != "(dynamic)" {
+		t.Errorf("dynamic row %q", lines[2])
+	}
+
+	b.Reset()
+	if err := write(&b, list, true); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Site
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0].Code != list[0].Code || decoded[0].Position != list[0].Position || !decoded[1].Dynamic {
+		t.Errorf("JSON = %s", b.String())
+	}
+}
@@ -0,0 +1,56 @@
+// Package sites holds one error site of each shape whose runtime name
+// errcatalog has to reproduce. TestCatalogMatchesRuntime checks the codes
+// the catalog computes for them against SiteCode.
+package sites
+
+import (
+	"fmt"
+
+	te "github.com/apepenkov/trace_errors"
+)
+
+func Plain() error {
+	return te.New("plain site")
+}
+
+func Formatted(n int) error {
+	return te.Newf("formatted site %d", n)
+}
+
+func Wrapped() error {
+	return te.Wrap(fmt.Errorf("cause"), "wrap site")
+}
+
+func Closure() error {
+	f := func() error {
+		g := func() error { return te.New("closure site") }
+		return g()
+	}
+	return f()
+}
+
+func Generic[T any](v T) error {
+	return te.Newf("generic site %v", v)
+}
+
+type Store struct{}
+
+func (*Store) Get() error {
+	return te.New("method site")
+}
+
+type Box[T any] struct{ V T }
+
+func (b *Box[T]) Get() error {
+	return te.Newf("generic method site %v", b.V)
+}
+
+func (b Box[T]) Value() error {
+	return te.Newf("generic value method site %v", b.V)
+}
+
+var Init = func() error { return te.New("init site") }
+
+func Dynamic(msg string) error {
+	return te.New(msg)
+}
@@ -0,0 +1,109 @@
+// Command teopen decrypts an error envelope sealed by a trace_errors
+// Keyring and prints the error chain it holds.
+//
+// Usage:
+//
+//	teopen -key 1:<hex key> [-key 2:<hex key>] [-format text] [envelope]
+//
+// Keys may also be given as a comma-separated list in TRACE_ERRORS_KEYS.
+// Without an envelope argument, it is read from standard input.
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	te "github.com/apepenkov/trace_errors"
+)
+
+type keyFlags []string
+
+func (k *keyFlags) String() string     { return strings.Join(*k, ",") }
+func (k *keyFlags) Set(v string) error { *k = append(*k, v); return nil }
+
+func main() {
+	var keys keyFlags
+	flag.Var(&keys, "key", "decryption key as `id:hex`; may be repeated")
+	format := flag.String("format", "text", "renderer used to print the error")
+	flag.Parse()
+
+	if env := os.Getenv("TRACE_ERRORS_KEYS"); env != "" {
+		keys = append(keys, strings.Split(env, ",")...)
+	}
+	if err := run(keys, *format, flag.Args(), os.Stdin, os.Stdout); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// run opens the envelope given in args, or read from stdin if there are
+// none, with keys and writes its error chain to stdout with the renderer
+// named format.
+func run(keys []string, format string, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(keys) == 0 {
+		return errors.New("no keys given; use -key or TRACE_ERRORS_KEYS")
+	}
+	ring, err := keyring(keys)
+	if err != nil {
+		return err
+	}
+
+	renderer, ok := te.LookupRenderer(format)
+	if !ok {
+		return fmt.Errorf("unknown format %q; available: %s", format, strings.Join(te.Renderers(), ", "))
+	}
+
+	var blob string
+	if len(args) > 0 {
+		blob = strings.Join(args, "")
+	} else {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("reading envelope: %v", err)
+		}
+		blob = string(data)
+	}
+
+	cause, err := ring.Open(blob)
+	if err != nil {
+		return err
+	}
+	if err := renderer.Render(stdout, cause, te.RenderOptions{Stack: true}); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout)
+	return err
+}
+
+// keyring returns a Keyring holding keys, each of the form id:hex.
+func keyring(keys []string) (*te.Keyring, error) {
+	ring := te.NewKeyring()
+	for _, k := range keys {
+		idText, keyText, ok := strings.Cut(strings.TrimSpace(k), ":")
+		if !ok {
+			return nil, fmt.Errorf("key %q is not of the form id:hex", k)
+		}
+		id, err := strconv.ParseUint(idText, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("key id %q: %v", idText, err)
+		}
+		key, err := hex.DecodeString(keyText)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", id, err)
+		}
+		if err := ring.Add(uint32(id), key); err != nil {
+			return nil, fmt.Errorf("key %d: %v", id, err)
+		}
+	}
+	return ring, nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "teopen: "+format+"\n", args...)
+	os.Exit(1)
+}
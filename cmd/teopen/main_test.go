@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	te "github.com/apepenkov/trace_errors"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 16)
+	key2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func seal(t *testing.T, id uint32, key []byte, err error) string {
+	t.Helper()
+	ring := te.NewKeyring()
+	if aerr := ring.Add(id, key); aerr != nil {
+		t.Fatal(aerr)
+	}
+	blob, serr := ring.Seal(err)
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return blob
+}
+
+func keyArg(id string, key []byte) string {
+	return id + ":" + hex.EncodeToString(key)
+}
+
+func TestRun(t *testing.T) {
+	blob := seal(t, 2, key2, te.Wrap(errors.New("connection refused"), "loading profile"))
+	keys := []string{keyArg("1", key1), keyArg("2", key2)}
+
+	var out bytes.Buffer
+	if err := run(keys, "text", []string{blob}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "loading profile: connection refused\n") {
+		t.Errorf("output %q does not start with the message chain", out.String())
+	}
+
+	// The envelope may come on standard input, wrapped across lines.
+	out.Reset()
+	stdin := strings.NewReader(blob[:20] + "\n" + blob[20:] + "\n")
+	if err := run(keys, "json", nil, stdin, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"message":"loading profile: connection refused"`) {
+		t.Errorf("json output %q does not hold the message", out.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	blob := seal(t, 1, key1, te.New("secret"))
+	flip := "A"
+	if blob[30] == 'A' {
+		flip = "B"
+	}
+	tampered := blob[:30] + flip + blob[31:]
+	tests := []struct {
+		name   string
+		keys   []string
+		format string
+		blob   string
+		want   string
+	}{
+		{"no keys", nil, "text", blob, "no keys given"},
+		{"malformed key", []string{"1"}, "text", blob, "not of the form id:hex"},
+		{"bad id", []string{keyArg("x", key1)}, "text", blob, `key id "x"`},
+		{"bad hex", []string{"1:zz"}, "text", blob, "key 1:"},
+		{"bad key size", []string{keyArg("1", key1[:5])}, "text", blob, "key 1:"},
+		{"unknown format", []string{keyArg("1", key1)}, "yaml", blob, `unknown format "yaml"`},
+		{"unknown key id", []string{keyArg("2", key1)}, "text", blob, "unknown key"},
+		{"wrong key", []string{keyArg("1", key2)}, "text", blob, "failed authentication"},
+		{"tampered", []string{keyArg("1", key1)}, "text", tampered, "failed authentication"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		err := run(tt.keys, tt.format, []string{tt.blob}, nil, &out)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: run = %v, want an error containing %q", tt.name, err, tt.want)
+		}
+		if out.Len() != 0 {
+			t.Errorf("%s: wrote %q", tt.name, out.String())
+		}
+	}
+}
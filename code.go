@@ -0,0 +1,31 @@
+package trace_errors
+
+import "fmt"
+
+// CodeField is the field that holds an error's code.
+const CodeField = "code"
+
+// WithCode wraps err with a stack frame and sets its code.
+func WithCode(err error, code string) error {
+	if err == nil {
+		return nil
+	}
+	return created(&TraceError{
+		Err:    err,
+		Frame:  captureStackFrame(),
+		Fields: map[string]interface{}{CodeField: code},
+	})
+}
+
+// Code returns the code of the outermost link of err that has one, or ""
+// if there is none.
+func Code(err error) string {
+	v, ok := FieldsOf(err)[CodeField]
+	if !ok {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
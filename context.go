@@ -0,0 +1,89 @@
+package trace_errors
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+)
+
+// NewCtx creates a new TraceError like New and copies the pprof labels
+// found in ctx into its fields.
+func NewCtx(ctx context.Context, msg string) error {
+	return created(&TraceError{
+		Msg:      msg,
+		Frame:    captureStackFrame(),
+		Fields:   labelFields(ctx),
+		template: msg,
+	})
+}
+
+// NewfCtx creates a new TraceError like Newf and copies the pprof labels
+// found in ctx into its fields.
+func NewfCtx(ctx context.Context, format string, args ...interface{}) error {
+	return created(&TraceError{
+		Msg:      fmt.Sprintf(format, args...),
+		Frame:    captureStackFrame(),
+		Fields:   labelFields(ctx),
+		template: format,
+	})
+}
+
+// WrapCtx wraps an existing error like Wrap and copies the pprof labels
+// found in ctx into its fields.
+func WrapCtx(ctx context.Context, err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return created(&TraceError{
+		Msg:      msg,
+		Err:      err,
+		Frame:    captureStackFrame(),
+		Fields:   labelFields(ctx),
+		template: msg,
+	})
+}
+
+// WrapfCtx wraps an existing error like Wrapf and copies the pprof labels
+// found in ctx into its fields.
+func WrapfCtx(ctx context.Context, err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return created(&TraceError{
+		Msg:      fmt.Sprintf(format, args...),
+		Err:      err,
+		Frame:    captureStackFrame(),
+		Fields:   labelFields(ctx),
+		template: format,
+	})
+}
+
+// WrapTraceCtx wraps an existing error like WrapTrace and copies the pprof
+// labels found in ctx into its fields.
+func WrapTraceCtx(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	return created(&TraceError{
+		Err:    err,
+		Frame:  captureStackFrame(),
+		Fields: labelFields(ctx),
+	})
+}
+
+// labelFields returns the pprof labels set on ctx as error fields,
+// or nil if there are none.
+func labelFields(ctx context.Context) map[string]interface{} {
+	if ctx == nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	pprof.ForLabels(ctx, func(key, value string) bool {
+		if fields == nil {
+			fields = make(map[string]interface{})
+		}
+		fields[key] = value
+		return true
+	})
+	return fields
+}
@@ -0,0 +1,46 @@
+package trace_errors
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+func TestCtxLabelFields(t *testing.T) {
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("tenant", "acme", "route", "/orders"))
+	outer := pprof.WithLabels(context.Background(), pprof.Labels("tenant", "other", "request", "r1"))
+
+	inner := NewCtx(ctx, "insert failed")
+	if te := inner.(*TraceError); !strings.Contains(te.Frame, "TestCtxLabelFields") {
+		t.Errorf("frame %q is not the caller's", te.Frame)
+	}
+	err := WrapCtx(outer, inner, "saving order")
+	want := map[string]interface{}{"tenant": "other", "route": "/orders", "request": "r1"}
+	if got := FieldsOf(err); !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldsOf = %v, want %v", got, want)
+	}
+
+	for name, err := range map[string]error{
+		"NewfCtx":      NewfCtx(ctx, "row %d", 1),
+		"WrapfCtx":     WrapfCtx(ctx, errors.New("x"), "row %d", 1),
+		"WrapTraceCtx": WrapTraceCtx(ctx, errors.New("x")),
+	} {
+		if got := FieldsOf(err); got["tenant"] != "acme" || got["route"] != "/orders" {
+			t.Errorf("%s: FieldsOf = %v", name, got)
+		}
+	}
+
+	if te := NewCtx(context.Background(), "no labels").(*TraceError); te.Fields != nil {
+		t.Errorf("fields without labels = %v, want nil", te.Fields)
+	}
+	var nilCtx context.Context
+	if te := NewCtx(nilCtx, "nil context").(*TraceError); te.Fields != nil {
+		t.Errorf("fields with a nil context = %v, want nil", te.Fields)
+	}
+	if WrapCtx(ctx, nil, "x") != nil || WrapfCtx(ctx, nil, "x") != nil || WrapTraceCtx(ctx, nil) != nil {
+		t.Error("wrapping nil returned an error")
+	}
+}
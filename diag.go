@@ -0,0 +1,267 @@
+package trace_errors
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiagnosticsOptions configures EnableDiagnostics.
+type DiagnosticsOptions struct {
+	// Path is the file reports are appended to. Empty means stderr.
+	Path string
+	// Size is the number of recent errors kept. Zero means 100.
+	Size int
+	// Signals trigger a report. Nil means SIGQUIT and SIGUSR1 on Unix
+	// systems and no signal elsewhere.
+	Signals []os.Signal
+}
+
+// Diagnostics records the TraceErrors created while it is enabled and
+// writes a report of them when one of its signals is received.
+type Diagnostics struct {
+	opts   DiagnosticsOptions
+	remove func()
+	sigs   chan os.Signal
+	done   chan struct{}
+	wg     sync.WaitGroup
+	stop   sync.Once
+
+	mu     sync.Mutex
+	recent []diagRecord // ring buffer of the last opts.Size errors
+	next   int
+	total  int
+	counts map[string]*diagCount
+}
+
+type diagRecord struct {
+	time time.Time
+	err  *TraceError
+}
+
+type diagCount struct {
+	n       int
+	example string
+}
+
+// EnableDiagnostics starts recording TraceErrors and writing a report on
+// every signal in opts.Signals. Receiving those signals no longer stops
+// the process until Stop is called.
+func EnableDiagnostics(opts DiagnosticsOptions) *Diagnostics {
+	if opts.Size <= 0 {
+		opts.Size = 100
+	}
+	if opts.Signals == nil {
+		opts.Signals = defaultDiagnosticsSignals
+	}
+	d := &Diagnostics{
+		opts:   opts,
+		recent: make([]diagRecord, opts.Size),
+		counts: make(map[string]*diagCount),
+		done:   make(chan struct{}),
+	}
+	d.remove = AddHook(d.record)
+	if len(opts.Signals) > 0 {
+		d.sigs = make(chan os.Signal, 1)
+		signal.Notify(d.sigs, opts.Signals...)
+		d.wg.Add(1)
+		go d.loop()
+	}
+	return d
+}
+
+// Stop stops recording errors and restores the default handling of the
+// signals. Calls after the first do nothing.
+func (d *Diagnostics) Stop() {
+	d.stop.Do(func() {
+		d.remove()
+		if d.sigs != nil {
+			signal.Stop(d.sigs)
+		}
+		close(d.done)
+	})
+	d.wg.Wait()
+}
+
+func (d *Diagnostics) loop() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.sigs:
+			d.dump()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Diagnostics) dump() {
+	if d.opts.Path == "" {
+		_ = d.WriteReport(os.Stderr)
+		return
+	}
+	f, err := os.OpenFile(d.opts.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "trace_errors: diagnostics: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if err := d.WriteReport(f); err != nil {
+		fmt.Fprintf(os.Stderr, "trace_errors: diagnostics: %v\n", err)
+	}
+}
+
+func (d *Diagnostics) record(e *TraceError) {
+	fp := Fingerprint(e)
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recent[d.next] = diagRecord{time: now, err: e}
+	d.next = (d.next + 1) % len(d.recent)
+	d.total++
+	c, ok := d.counts[fp]
+	if !ok {
+		c = &diagCount{example: message(e)}
+		d.counts[fp] = c
+	}
+	c.n++
+}
+
+// WriteReport writes the recent errors, the error counts by fingerprint and
+// the goroutine stacks grouped by identical stack to w.
+func (d *Diagnostics) WriteReport(w io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "=== trace_errors diagnostics %s ===\n", time.Now().Format(time.RFC3339))
+
+	d.mu.Lock()
+	var recent []diagRecord
+	for i := 0; i < len(d.recent); i++ {
+		r := d.recent[(d.next+i)%len(d.recent)]
+		if r.err != nil {
+			recent = append(recent, r)
+		}
+	}
+	total := d.total
+	type countLine struct {
+		fp string
+		diagCount
+	}
+	counts := make([]countLine, 0, len(d.counts))
+	for fp, c := range d.counts {
+		counts = append(counts, countLine{fp, *c})
+	}
+	d.mu.Unlock()
+
+	fmt.Fprintf(&b, "\n--- recent errors (%d of %d) ---\n", len(recent), total)
+	for _, r := range recent {
+		fmt.Fprintf(&b, "%s [%s] %s\n", r.time.Format(time.RFC3339Nano), Fingerprint(r.err), message(r.err))
+		if trace := StackTrace(r.err); trace != "" {
+			b.WriteString(indent(trace, "    "))
+		}
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].n != counts[j].n {
+			return counts[i].n > counts[j].n
+		}
+		return counts[i].fp < counts[j].fp
+	})
+	b.WriteString("\n--- errors by fingerprint ---\n")
+	for _, c := range counts {
+		fmt.Fprintf(&b, "%8d %s %s\n", c.n, c.fp, c.example)
+	}
+
+	writeGoroutines(&b)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeGoroutines writes the stacks of all goroutines, grouping goroutines
+// with identical stacks.
+func writeGoroutines(b *bytes.Buffer) {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	type group struct {
+		stack  string
+		states map[string]int
+		ids    []string
+	}
+	groups := make(map[string]*group)
+	var order []*group
+	for _, block := range strings.Split(strings.TrimSpace(string(buf)), "\n\n") {
+		header, stack, _ := strings.Cut(block, "\n")
+		stack = normalizeStack(stack)
+		// header looks like "goroutine 7 [chan receive, 3 minutes]:"
+		id, state := header, ""
+		if i := strings.IndexByte(header, '['); i >= 0 {
+			id = strings.TrimSpace(strings.TrimPrefix(header[:i], "goroutine "))
+			state, _, _ = strings.Cut(strings.TrimSuffix(header[i+1:], "]:"), ",")
+		}
+		g, ok := groups[stack]
+		if !ok {
+			g = &group{stack: stack, states: make(map[string]int)}
+			groups[stack] = g
+			order = append(order, g)
+		}
+		g.states[state]++
+		g.ids = append(g.ids, id)
+	}
+	sort.SliceStable(order, func(i, j int) bool { return len(order[i].ids) > len(order[j].ids) })
+
+	fmt.Fprintf(b, "\n--- goroutines (%d in %d groups) ---\n", strings.Count(string(buf), "\ngoroutine ")+1, len(order))
+	for _, g := range order {
+		states := make([]string, 0, len(g.states))
+		for s, n := range g.states {
+			states = append(states, fmt.Sprintf("%s x%d", s, n))
+		}
+		sort.Strings(states)
+		ids := g.ids
+		if len(ids) > 10 {
+			ids = append(ids[:10:10], "...")
+		}
+		fmt.Fprintf(b, "%d goroutines [%s] ids %s:\n", len(g.ids), strings.Join(states, ", "), strings.Join(ids, " "))
+		b.WriteString(indent(g.stack, "    "))
+		b.WriteByte('\n')
+	}
+}
+
+// normalizeStack drops argument values, PC offsets and goroutine numbers
+// from a goroutine stack so that goroutines running the same code compare
+// equal.
+func normalizeStack(stack string) string {
+	lines := strings.Split(stack, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "\t") {
+			if j := strings.LastIndex(line, " +0x"); j >= 0 {
+				lines[i] = line[:j]
+			}
+			continue
+		}
+		if j := strings.Index(line, " in goroutine "); j >= 0 && strings.HasPrefix(line, "created by ") {
+			lines[i] = line[:j]
+		} else if j := strings.LastIndexByte(line, '('); j > 0 && strings.HasSuffix(line, ")") {
+			lines[i] = line[:j] + "(...)"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// indent prefixes every line of s and makes sure it ends in a newline.
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(strings.TrimSuffix(s, "\n"), "\n", "\n"+prefix) + "\n"
+}
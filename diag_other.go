@@ -0,0 +1,7 @@
+//go:build !unix
+
+package trace_errors
+
+import "os"
+
+var defaultDiagnosticsSignals = []os.Signal{}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package trace_errors
+
+import (
+	"os"
+	"syscall"
+)
+
+var defaultDiagnosticsSignals = []os.Signal{syscall.SIGQUIT, syscall.SIGUSR1}
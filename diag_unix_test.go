@@ -0,0 +1,65 @@
+//go:build unix
+
+package trace_errors
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticsSignal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diag.txt")
+	d := EnableDiagnostics(DiagnosticsOptions{
+		Path:    path,
+		Size:    2,
+		Signals: []os.Signal{syscall.SIGUSR1},
+	})
+	defer d.Stop()
+
+	for i := 0; i < 3; i++ {
+		_ = New("diag-test")
+	}
+	fp := Fingerprint(New("diag-test"))
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	var report string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		b, err := os.ReadFile(path)
+		if err == nil && strings.Contains(string(b), "goroutine") {
+			report = string(b)
+			break
+		}
+	}
+	if report == "" {
+		t.Fatal("no report written after SIGUSR1")
+	}
+	for _, want := range []string{"recent errors (2 of 4)", "errors by fingerprint", fp + " diag-test"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestDiagnosticsStopTwice(t *testing.T) {
+	d := EnableDiagnostics(DiagnosticsOptions{
+		Path:    filepath.Join(t.TempDir(), "diag.txt"),
+		Signals: []os.Signal{syscall.SIGUSR1},
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Stop()
+		}()
+	}
+	wg.Wait()
+	d.Stop()
+}
@@ -0,0 +1,84 @@
+package trace_errors
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// DecodedError stands for an error of a foreign type restored by
+// UnmarshalChain. It keeps the message and type name of the original.
+type DecodedError struct {
+	Msg  string
+	Type string
+	Err  error
+	// Prefix is set when Msg is only the text the original wrote in front
+	// of the text of its cause, as fmt.Errorf("...: %w") does.
+	Prefix bool
+}
+
+// Error implements the error interface. Like the original, it is Msg
+// followed by the text of the cause when Prefix is set.
+func (e *DecodedError) Error() string {
+	if e.Prefix && e.Err != nil {
+		return e.Msg + e.Err.Error()
+	}
+	return e.Msg
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodedError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalChain serializes the error chain of err, frames and fields
+// included, in the format written by the "json" renderer.
+func MarshalChain(err error) ([]byte, error) {
+	if err == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(toJSON(err, RenderOptions{Stack: true}))
+}
+
+// UnmarshalChain rebuilds an error chain from the output of MarshalChain.
+// TraceError and RemoteError links are restored as such, multi-errors as
+// errors.Join and every other link as a DecodedError.
+func UnmarshalChain(data []byte) (error, error) {
+	var je *jsonError
+	if err := json.Unmarshal(data, &je); err != nil {
+		return nil, err
+	}
+	if je == nil {
+		return nil, nil
+	}
+	return fromJSON(*je), nil
+}
+
+func fromJSON(je jsonError) error {
+	var err error
+	for i := len(je.Chain) - 1; i >= 0; i-- {
+		jl := je.Chain[i]
+		switch {
+		case len(jl.Errors) > 0:
+			branches := make([]error, 0, len(jl.Errors))
+			for _, b := range jl.Errors {
+				branches = append(branches, fromJSON(b))
+			}
+			err = errors.Join(branches...)
+		case jl.Type == typeName(&TraceError{}):
+			e := &TraceError{Msg: jl.Msg, Err: err, Fields: jl.Fields, Runtime: jl.Runtime, Enqueued: jl.Enqueued, site: jl.Site}
+			if jl.Function != "" {
+				e.Frame = jl.Function
+				if jl.File != "" {
+					e.Frame += "\n\t" + jl.File + ":" + strconv.Itoa(jl.Line)
+				}
+			}
+			err = e
+		case jl.Remote != nil && err == nil:
+			err = jl.Remote
+		default:
+			err = &DecodedError{Msg: jl.Msg, Type: jl.Type, Err: err, Prefix: jl.Prefix}
+		}
+	}
+	return err
+}
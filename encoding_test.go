@@ -0,0 +1,37 @@
+package trace_errors_test
+
+import (
+	"strings"
+	"testing"
+
+	te "github.com/apepenkov/trace_errors"
+	"github.com/apepenkov/trace_errors/tetest"
+)
+
+func TestChainRoundTrip(t *testing.T) {
+	for _, c := range tetest.Corpus() {
+		if strings.Contains(c.Name, "cycle") {
+			continue // a serialized chain ends where the cycle starts
+		}
+		data, err := te.MarshalChain(c.Err)
+		if err != nil {
+			t.Errorf("%s: MarshalChain: %v", c.Name, err)
+			continue
+		}
+		decoded, err := te.UnmarshalChain(data)
+		if err != nil {
+			t.Errorf("%s: UnmarshalChain: %v", c.Name, err)
+			continue
+		}
+		if got, want := decoded.Error(), c.Err.Error(); got != want {
+			t.Errorf("%s: decoded Error() =\n%q\nwant\n%q", c.Name, trim(got), trim(want))
+		}
+	}
+}
+
+func trim(s string) string {
+	if len(s) > 300 {
+		return s[:300] + "..."
+	}
+	return s
+}
@@ -0,0 +1,263 @@
+package trace_errors
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Cardinality hints how many distinct values a field takes, which tells
+// dashboards whether it is safe to group by.
+type Cardinality int
+
+const (
+	CardinalityUnknown Cardinality = iota
+	// CardinalityLow is for fields with a small, bounded set of values,
+	// such as error codes or endpoints.
+	CardinalityLow
+	// CardinalityHigh is for fields such as user or request IDs.
+	CardinalityHigh
+)
+
+// String returns the name of the cardinality.
+func (c Cardinality) String() string {
+	switch c {
+	case CardinalityLow:
+		return "low"
+	case CardinalityHigh:
+		return "high"
+	}
+	return "unknown"
+}
+
+// FieldKey is a registered field key with the type of its values.
+type FieldKey struct {
+	Name        string
+	Type        reflect.Type
+	Description string
+	Cardinality Cardinality
+}
+
+// Field is a key and value to attach to an error with WithFields.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+var (
+	fieldKeysMu sync.RWMutex
+	fieldKeys   = make(map[string]*FieldKey)
+)
+
+func init() {
+	RegisterFieldKey(CodeField, "", "error code", CardinalityLow)
+	RegisterFieldKey(ItemField, "", "item of a bulk operation that failed", CardinalityHigh)
+	RegisterFieldKey(HTTPStatusField, 0, "HTTP status reported for a failed item", CardinalityLow)
+	RegisterFieldKey(SingleflightKeyField, "", "key of a deduplicated call", CardinalityHigh)
+	RegisterFieldKey(SingleflightSharedField, false, "whether a call result was shared", CardinalityLow)
+}
+
+// RegisterFieldKey registers the field key name, whose values have the
+// type of example, and returns it:
+//
+//	var UserID = trace_errors.RegisterFieldKey("user_id", int64(0), "ID of the acting user", trace_errors.CardinalityHigh)
+//
+//	return trace_errors.WithFields(err, UserID.Of(42))
+//
+// NewKey does the same for keys whose values are checked at compile time.
+// It panics if name is empty, example is nil or name is already
+// registered.
+func RegisterFieldKey(name string, example interface{}, description string, cardinality Cardinality) *FieldKey {
+	if example == nil {
+		panic("trace_errors: RegisterFieldKey example is nil")
+	}
+	return registerFieldKey(name, reflect.TypeOf(example), description, cardinality)
+}
+
+func registerFieldKey(name string, typ reflect.Type, description string, cardinality Cardinality) *FieldKey {
+	if name == "" {
+		panic("trace_errors: RegisterFieldKey with empty name")
+	}
+	k := &FieldKey{
+		Name:        name,
+		Type:        typ,
+		Description: description,
+		Cardinality: cardinality,
+	}
+	fieldKeysMu.Lock()
+	defer fieldKeysMu.Unlock()
+	if _, dup := fieldKeys[name]; dup {
+		panic("trace_errors: RegisterFieldKey called twice for " + name)
+	}
+	fieldKeys[name] = k
+	return k
+}
+
+// Key is a registered field key whose values have type T.
+type Key[T any] struct {
+	key *FieldKey
+}
+
+// NewKey registers the field key name with values of type T and returns
+// it as a typed key:
+//
+//	var UserID = trace_errors.NewKey[int64]("user_id", "ID of the acting user", trace_errors.CardinalityHigh)
+//
+//	return trace_errors.WithFields(err, UserID.Of(42))
+//
+// It panics if name is empty or already registered.
+func NewKey[T any](name, description string, cardinality Cardinality) Key[T] {
+	return Key[T]{registerFieldKey(name, reflect.TypeOf((*T)(nil)).Elem(), description, cardinality)}
+}
+
+// Of returns a field of key k.
+func (k Key[T]) Of(v T) Field {
+	return Field{Key: k.key.Name, Value: v}
+}
+
+// FieldKey returns the registered key.
+func (k Key[T]) FieldKey() *FieldKey {
+	return k.key
+}
+
+// LookupFieldKey returns the field key registered under name.
+func LookupFieldKey(name string) (*FieldKey, bool) {
+	fieldKeysMu.RLock()
+	defer fieldKeysMu.RUnlock()
+	k, ok := fieldKeys[name]
+	return k, ok
+}
+
+// FieldKeys returns the registered field keys sorted by name.
+func FieldKeys() []*FieldKey {
+	fieldKeysMu.RLock()
+	defer fieldKeysMu.RUnlock()
+	keys := make([]*FieldKey, 0, len(fieldKeys))
+	for _, k := range fieldKeys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// Of returns a field of key k. Numbers are converted to the key's type if
+// no precision or sign is lost, so UserID.Of(42) holds an int64 when UserID
+// was registered with one. Other values, such as UserID.Of(3.9), are kept
+// as they are and reported by the checks of SetFieldDebug.
+func (k *FieldKey) Of(v interface{}) Field {
+	if rv := reflect.ValueOf(v); rv.IsValid() && rv.Type() != k.Type && isNumber(rv.Kind()) && isNumber(k.Type.Kind()) {
+		if cv, ok := convertNumber(rv, k.Type); ok {
+			v = cv.Interface()
+		}
+	}
+	return Field{Key: k.Name, Value: v}
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+// convertNumber converts v to typ and reports whether the conversion kept
+// its value: converting back gives v and the sign is unchanged.
+func convertNumber(v reflect.Value, typ reflect.Type) (reflect.Value, bool) {
+	cv := v.Convert(typ)
+	if cv.Convert(v.Type()).Interface() != v.Interface() {
+		return v, false
+	}
+	if isNegative(cv) != isNegative(v) {
+		return v, false
+	}
+	return cv, true
+}
+
+func isNegative(v reflect.Value) bool {
+	switch {
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		return v.Int() < 0
+	case v.Kind() >= reflect.Float32:
+		return v.Float() < 0
+	}
+	return false
+}
+
+// WithFields wraps err with a stack frame and the given fields.
+func WithFields(err error, fields ...Field) error {
+	if err == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		m[f.Key] = f.Value
+	}
+	return created(&TraceError{
+		Err:    err,
+		Frame:  captureStackFrame(),
+		Fields: m,
+	})
+}
+
+// FieldViolation describes a field that does not match the registry.
+type FieldViolation struct {
+	Key   string
+	Value interface{}
+	// Want is the registered type, or nil if the key is not registered.
+	Want reflect.Type
+	// Frame is the frame of the TraceError the field was attached to.
+	Frame string
+}
+
+// String describes the violation.
+func (v FieldViolation) String() string {
+	function, file, line := splitFrame(v.Frame)
+	if v.Want == nil {
+		return fmt.Sprintf("trace_errors: unregistered field %q at %s (%s:%d)", v.Key, function, file, line)
+	}
+	return fmt.Sprintf("trace_errors: field %q is %T, want %s, at %s (%s:%d)", v.Key, v.Value, v.Want, function, file, line)
+}
+
+// fieldDebug holds a fieldDebugBox, or nothing when debugging is off.
+var fieldDebug atomic.Value
+
+type fieldDebugBox struct {
+	report func(FieldViolation)
+}
+
+// SetFieldDebug makes every constructor check the fields it attaches
+// against the registry and call report for each unregistered key or value
+// of the wrong type. A nil report turns the checks off.
+func SetFieldDebug(report func(FieldViolation)) {
+	fieldDebug.Store(fieldDebugBox{report})
+}
+
+// checkFields reports the fields of e that do not match the registry.
+func checkFields(e *TraceError) {
+	box, _ := fieldDebug.Load().(fieldDebugBox)
+	if box.report == nil || len(e.Fields) == 0 {
+		return
+	}
+	for key, value := range e.Fields {
+		k, ok := LookupFieldKey(key)
+		if !ok {
+			box.report(FieldViolation{Key: key, Value: value, Frame: e.Frame})
+		} else if !assignable(value, k.Type) {
+			box.report(FieldViolation{Key: key, Value: value, Want: k.Type, Frame: e.Frame})
+		}
+	}
+}
+
+// assignable reports whether value can be held by a variable of type typ,
+// so that an *os.PathError matches a key of type error. A nil value matches
+// the types that have nil.
+func assignable(value interface{}, typ reflect.Type) bool {
+	vt := reflect.TypeOf(value)
+	if vt == nil {
+		switch typ.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return true
+		}
+		return false
+	}
+	return vt.AssignableTo(typ)
+}
@@ -0,0 +1,78 @@
+package trace_errors
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFieldKeyOf(t *testing.T) {
+	k := RegisterFieldKey("test_of_int64", int64(0), "", CardinalityUnknown)
+	u := RegisterFieldKey("test_of_uint8", uint8(0), "", CardinalityUnknown)
+	tests := []struct {
+		key  *FieldKey
+		in   interface{}
+		want interface{}
+	}{
+		{k, 42, int64(42)},
+		{k, 3.0, int64(3)},
+		{k, 3.9, 3.9},
+		{k, uint64(1 << 63), uint64(1 << 63)},
+		{k, "x", "x"},
+		{u, 255, uint8(255)},
+		{u, 256, 256},
+		{u, -1, -1},
+	}
+	for _, tt := range tests {
+		if got := tt.key.Of(tt.in).Value; got != tt.want {
+			t.Errorf("%s.Of(%#v) = %#v, want %#v", tt.key.Name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	k := NewKey[int64]("test_new_key", "a test key", CardinalityHigh)
+	f := k.Of(42)
+	if f.Key != "test_new_key" || f.Value != int64(42) {
+		t.Errorf("Of(42) = %#v", f)
+	}
+	registered, ok := LookupFieldKey("test_new_key")
+	if !ok || registered != k.FieldKey() || registered.Type != reflect.TypeOf(int64(0)) {
+		t.Errorf("registered key = %#v", registered)
+	}
+
+	e := NewKey[error]("test_new_key_interface", "", CardinalityUnknown)
+	if got := e.FieldKey().Type; got != reflect.TypeOf((*error)(nil)).Elem() {
+		t.Errorf("Type = %v, want error", got)
+	}
+}
+
+func TestFieldDebugTypes(t *testing.T) {
+	errKey := NewKey[error]("test_debug_error", "", CardinalityUnknown)
+	count := NewKey[int]("test_debug_int", "", CardinalityUnknown)
+
+	var got []FieldViolation
+	SetFieldDebug(func(v FieldViolation) { got = append(got, v) })
+	defer SetFieldDebug(nil)
+
+	tests := []struct {
+		name  string
+		field Field
+		ok    bool
+	}{
+		{"concrete error", errKey.Of(&os.PathError{Op: "open"}), true},
+		{"nil error", Field{Key: errKey.FieldKey().Name, Value: nil}, true},
+		{"int", count.Of(1), true},
+		{"nil int", Field{Key: count.FieldKey().Name, Value: nil}, false},
+		{"string for int", Field{Key: count.FieldKey().Name, Value: "1"}, false},
+		{"string for error", Field{Key: errKey.FieldKey().Name, Value: "x"}, false},
+	}
+	for _, tt := range tests {
+		got = nil
+		_ = WithFields(errors.New("leaf"), tt.field)
+		if ok := len(got) == 0; ok != tt.ok {
+			t.Errorf("%s: violations %v, want ok=%v", tt.name, got, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package trace_errors
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Filter is a compiled filter expression that selects error chains.
+//
+// An expression compares attributes of an error with values:
+//
+//	code = "NotFound" and pkg = "app/db" and field.tenant != "test"
+//
+// Comparisons are joined with and, or and not (or &&, || and !) and
+// grouped with parentheses. The operators are = (also ==), !=, ~ (glob
+// match, where * matches any run of characters and ? a single one) and
+// !~. Values are Go-style quoted strings or bare words and numbers.
+//
+// The attributes are:
+//
+//	code         the error code, see Code
+//	msg          the message chain
+//	fingerprint  see Fingerprint
+//	field.NAME   the field NAME, see FieldsOf
+//	type         the type of any link
+//	func         the function of any TraceError frame
+//	pkg          the package of any TraceError frame
+//	file         the file of any TraceError frame
+//	line         the line of any TraceError frame
+//	site         the site code of any TraceError, see SiteCode
+//
+// The last six hold when any link of the chain, multi-error branches
+// included, satisfies the comparison; != and !~ hold when none does.
+// Likewise field.NAME != and !~ hold when the field is not set.
+//
+// A package is compared by its import path and by every part of the path
+// that follows a slash, so "app/db" and "db" both match the package
+// github.com/acme/app/db, and ~ "app/db/*" matches its subpackages.
+type Filter struct {
+	src  string
+	root filterNode
+}
+
+// FilterSyntaxError describes a malformed filter expression.
+type FilterSyntaxError struct {
+	Expr string // the expression
+	Pos  int    // byte offset of the problem in Expr
+	Msg  string
+}
+
+// Error implements the error interface, pointing at the offending column.
+func (e *FilterSyntaxError) Error() string {
+	col := utf8.RuneCountInString(e.Expr[:e.Pos])
+	return fmt.Sprintf("filter: %s at column %d\n\t%s\n\t%s^", e.Msg, col+1, e.Expr, strings.Repeat(" ", col))
+}
+
+// ParseFilter compiles a filter expression. The empty expression matches
+// every error.
+func ParseFilter(expr string) (*Filter, error) {
+	p := &filterParser{src: expr}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokEOF {
+		return &Filter{src: expr}, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &Filter{src: expr, root: root}, nil
+}
+
+// MustParseFilter is like ParseFilter but panics if the expression cannot
+// be parsed.
+func MustParseFilter(expr string) *Filter {
+	f, err := ParseFilter(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// String returns the source of the filter.
+func (f *Filter) String() string {
+	return f.src
+}
+
+// Match reports whether err satisfies the filter. A nil error never does.
+func (f *Filter) Match(err error) bool {
+	if err == nil {
+		return false
+	}
+	if f.root == nil {
+		return true
+	}
+	return f.root.eval(&filterSubject{err: err})
+}
+
+// filterSubject caches what the comparisons read from an error.
+type filterSubject struct {
+	err    error
+	fields map[string]interface{}
+	links  []error
+}
+
+func (s *filterSubject) allLinks() []error {
+	if s.links == nil {
+		s.links = allLinks(s.err, make(map[error]struct{}))
+	}
+	return s.links
+}
+
+// allLinks returns every link of err, descending into multi-error branches.
+func allLinks(err error, seen map[error]struct{}) []error {
+	var links []error
+	for _, link := range chain(err) {
+		if isPointer(link) {
+			if _, dup := seen[link]; dup {
+				break
+			}
+			seen[link] = struct{}{}
+		}
+		links = append(links, link)
+		for _, branch := range branches(link) {
+			if branch != nil {
+				links = append(links, allLinks(branch, seen)...)
+			}
+		}
+	}
+	return links
+}
+
+type filterNode interface {
+	eval(s *filterSubject) bool
+}
+
+type andNode struct{ l, r filterNode }
+
+func (n andNode) eval(s *filterSubject) bool { return n.l.eval(s) && n.r.eval(s) }
+
+type orNode struct{ l, r filterNode }
+
+func (n orNode) eval(s *filterSubject) bool { return n.l.eval(s) || n.r.eval(s) }
+
+type notNode struct{ x filterNode }
+
+func (n notNode) eval(s *filterSubject) bool { return !n.x.eval(s) }
+
+// cmpNode compares one attribute with a value.
+type cmpNode struct {
+	attr   string
+	field  string // the NAME of field.NAME
+	negate bool
+	value  string
+	glob   *regexp.Regexp // set for ~ and !~
+}
+
+func (n cmpNode) eval(s *filterSubject) bool {
+	return n.matchAttr(s) != n.negate
+}
+
+func (n cmpNode) match(v string) bool {
+	if n.glob != nil {
+		return n.glob.MatchString(v)
+	}
+	return v == n.value
+}
+
+// matchPath reports whether path, or a part of it following a slash,
+// matches.
+func (n cmpNode) matchPath(path string) bool {
+	for {
+		if n.match(path) {
+			return true
+		}
+		i := strings.IndexByte(path, '/')
+		if i < 0 {
+			return false
+		}
+		path = path[i+1:]
+	}
+}
+
+func (n cmpNode) matchAttr(s *filterSubject) bool {
+	switch n.attr {
+	case "code":
+		return n.match(Code(s.err))
+	case "msg":
+		return n.match(message(s.err))
+	case "fingerprint":
+		return n.match(Fingerprint(s.err))
+	case "field":
+		if s.fields == nil {
+			s.fields = FieldsOf(s.err)
+		}
+		v, ok := s.fields[n.field]
+		return ok && n.match(fmt.Sprint(v))
+	}
+	for _, link := range s.allLinks() {
+		if n.attr == "type" {
+			if n.match(typeName(link)) {
+				return true
+			}
+			continue
+		}
+		e, ok := link.(*TraceError)
+		if !ok || e.Frame == "" {
+			continue
+		}
+		function, file, line := splitFrame(e.Frame)
+		var v string
+		switch n.attr {
+		case "site":
+			v = e.siteCode()
+		case "func":
+			v = function
+		case "pkg":
+			if n.matchPath(goPackage(function)) {
+				return true
+			}
+			continue
+		case "file":
+			v = file
+		case "line":
+			v = strconv.Itoa(line)
+		}
+		if n.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+var filterAttrs = map[string]bool{
+	"code": true, "msg": true, "fingerprint": true, "type": true,
+	"func": true, "pkg": true, "file": true, "line": true, "site": true,
+}
+
+// globRegexp translates a glob pattern into an anchored regular expression.
+func globRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`\A(?s:`)
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(`)\z`)
+	return regexp.MustCompile(b.String())
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return "string " + strconv.Quote(t.text)
+	}
+	return strconv.Quote(t.text)
+}
+
+type filterParser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return &FilterSyntaxError{Expr: p.src, Pos: p.tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// next reads the next token into p.tok.
+func (p *filterParser) next() error {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\n' || p.src[p.pos] == '\r') {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+	rest := p.src[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "&&"):
+		p.pos += 2
+		p.tok = token{tokAnd, "&&", start}
+	case strings.HasPrefix(rest, "||"):
+		p.pos += 2
+		p.tok = token{tokOr, "||", start}
+	case strings.HasPrefix(rest, "!="), strings.HasPrefix(rest, "!~"), strings.HasPrefix(rest, "=="):
+		p.pos += 2
+		p.tok = token{tokOp, rest[:2], start}
+	case rest[0] == '=' || rest[0] == '~':
+		p.pos++
+		p.tok = token{tokOp, rest[:1], start}
+	case rest[0] == '!':
+		p.pos++
+		p.tok = token{tokNot, "!", start}
+	case rest[0] == '(':
+		p.pos++
+		p.tok = token{tokLParen, "(", start}
+	case rest[0] == ')':
+		p.pos++
+		p.tok = token{tokRParen, ")", start}
+	case rest[0] == '"':
+		end := 1
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			p.tok = token{pos: start}
+			return p.errorf("unterminated string")
+		}
+		s, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			p.tok = token{pos: start}
+			return p.errorf("invalid string %s", rest[:end+1])
+		}
+		p.pos += end + 1
+		p.tok = token{tokString, s, start}
+	default:
+		end := 0
+		for end < len(rest) {
+			r, size := utf8.DecodeRuneInString(rest[end:])
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_.-/*?:@", r) {
+				break
+			}
+			end += size
+		}
+		if end == 0 {
+			r, _ := utf8.DecodeRuneInString(rest)
+			p.tok = token{pos: start}
+			return p.errorf("unexpected character %q", r)
+		}
+		word := rest[:end]
+		p.pos += end
+		switch strings.ToLower(word) {
+		case "and":
+			p.tok = token{tokAnd, word, start}
+		case "or":
+			p.tok = token{tokOr, word, start}
+		case "not":
+			p.tok = token{tokNot, word, start}
+		default:
+			p.tok = token{tokWord, word, start}
+		}
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOr {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokAnd {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	switch p.tok.kind {
+	case tokNot:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	case tokLParen:
+		open := p.tok
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			if p.tok.kind == tokEOF {
+				p.tok = open
+				return nil, p.errorf("unclosed parenthesis")
+			}
+			return nil, p.errorf("expected ) but found %s", p.tok)
+		}
+		return x, p.next()
+	case tokWord:
+		return p.parseComparison()
+	case tokEOF:
+		return nil, p.errorf("expected a comparison but the expression ended")
+	}
+	return nil, p.errorf("expected a comparison but found %s", p.tok)
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	n := cmpNode{attr: p.tok.text}
+	if name, ok := strings.CutPrefix(n.attr, "field."); ok {
+		if name == "" {
+			return nil, p.errorf("missing field name after \"field.\"")
+		}
+		n.attr, n.field = "field", name
+	} else if !filterAttrs[n.attr] {
+		return nil, p.errorf("unknown attribute %q (want code, msg, fingerprint, type, func, pkg, file, line, site or field.NAME)", n.attr)
+	}
+	attr := p.tok.text
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokOp {
+		return nil, p.errorf("expected =, !=, ~ or !~ after %q but found %s", attr, p.tok)
+	}
+	op := p.tok.text
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokString && p.tok.kind != tokWord {
+		return nil, p.errorf("expected a value after %s but found %s", op, p.tok)
+	}
+	n.value = p.tok.text
+	n.negate = op == "!=" || op == "!~"
+	if op == "~" || op == "!~" {
+		n.glob = globRegexp(n.value)
+	}
+	return n, p.next()
+}
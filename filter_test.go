@@ -0,0 +1,132 @@
+package trace_errors
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	err := WithFields(WithCode(New("not found"), "NotFound"), Field{Key: ItemField, Value: "42"})
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`code = "NotFound"`, true},
+		{`code = NotFound and msg ~ "*found"`, true},
+
+		// and binds tighter than or, not tighter than and.
+		{`code = "x" or code = "NotFound" and msg = "y"`, false},
+		{`code = "NotFound" or code = "x" and msg = "y"`, true},
+		{`(code = "NotFound" or code = "x") and msg = "y"`, false},
+		{`not code = "x" and msg = "y"`, false},
+		{`not (code = "x" and msg = "y")`, true},
+		{`! code = "x" || msg = "y"`, true},
+
+		{`field.item = 42`, true},
+		{`field.tenant != "test"`, true},
+		{`field.tenant !~ "*"`, true},
+		{`field.tenant = ""`, false},
+		{`field.tenant ~ "*"`, false},
+
+		{`pkg = "github.com/apepenkov/trace_errors"`, true},
+		{`pkg = "apepenkov/trace_errors"`, true},
+		{`pkg = "trace_errors"`, true},
+		{`pkg = "errors"`, false},
+		{`pkg ~ "apepenkov/*"`, true},
+		{`pkg ~ "trace_errors/*"`, false},
+		{`pkg != "trace_errors"`, false},
+
+		{`func ~ "*.TestFilterMatch"`, true},
+		{`file ~ "*filter_test.go"`, true},
+		{`type = "*trace_errors.TraceError"`, true},
+		{``, true},
+	}
+	for _, tt := range tests {
+		f, perr := ParseFilter(tt.expr)
+		if perr != nil {
+			t.Errorf("ParseFilter(%q): %v", tt.expr, perr)
+			continue
+		}
+		if got := f.Match(err); got != tt.want {
+			t.Errorf("%s: Match = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestFilterMultiError(t *testing.T) {
+	err := errors.Join(errors.New("plain"), WithCode(New("inner"), "Inner"))
+	if !MustParseFilter(`type = "*trace_errors.TraceError"`).Match(err) {
+		t.Error("a link in a branch does not match")
+	}
+	if MustParseFilter(`code = "Inner"`).Match(err) {
+		t.Error("code matched through a branch")
+	}
+}
+
+func TestFilterSyntaxError(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+		msg  string
+	}{
+		{`code`, 4, "expected =, !=, ~ or !~"},
+		{`code = `, 7, "expected a value"},
+		{`bogus = 1`, 0, "unknown attribute"},
+		{`field. = 1`, 0, "missing field name"},
+		{`code = "x" and`, 14, "expected a comparison"},
+		{`(code = "x"`, 0, "unclosed parenthesis"},
+		{`code = "x")`, 10, "unexpected \")\""},
+		{`code = "x`, 7, "unterminated string"},
+		{`msg = "é" and # = 1`, 15, "unexpected character"},
+	}
+	for _, tt := range tests {
+		_, err := ParseFilter(tt.expr)
+		var serr *FilterSyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("ParseFilter(%q) = %v, want a FilterSyntaxError", tt.expr, err)
+			continue
+		}
+		if serr.Pos != tt.pos || !strings.Contains(serr.Msg, tt.msg) {
+			t.Errorf("ParseFilter(%q): %q at %d, want %q at %d", tt.expr, serr.Msg, serr.Pos, tt.msg, tt.pos)
+		}
+	}
+
+	// The column counts characters, not bytes.
+	_, err := ParseFilter(`msg = "é" and # = 1`)
+	if want := "at column 15\n\tmsg = \"é\" and # = 1\n\t" + strings.Repeat(" ", 14) + "^"; !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("Error() = %q, want suffix %q", err.Error(), want)
+	}
+}
+
+func FuzzParseFilter(f *testing.F) {
+	for _, seed := range []string{
+		`code = "NotFound" and pkg = "app/db" and field.tenant != "test"`,
+		`not (msg ~ "*timeout*" || type == "*net.OpError") && line = 42`,
+		`field.x !~ "a?c"`,
+		`(((`,
+		`"é"`,
+		`msg = "é" and # = 1`,
+	} {
+		f.Add(seed)
+	}
+	err := errors.Join(WithCode(New("fuzz"), "Fuzz"), errors.New("plain"))
+	f.Fuzz(func(t *testing.T, expr string) {
+		filter, perr := ParseFilter(expr)
+		if perr != nil {
+			var serr *FilterSyntaxError
+			if !errors.As(perr, &serr) {
+				t.Fatalf("got %T, want *FilterSyntaxError", perr)
+			}
+			if serr.Pos < 0 || serr.Pos > len(expr) {
+				t.Fatalf("Pos %d out of range for %q", serr.Pos, expr)
+			}
+			_ = serr.Error()
+			return
+		}
+		if filter.String() != expr {
+			t.Fatalf("String() = %q, want %q", filter.String(), expr)
+		}
+		filter.Match(err)
+	})
+}
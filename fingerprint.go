@@ -0,0 +1,58 @@
+package trace_errors
+
+import (
+	"hash/fnv"
+	"io"
+	"strconv"
+)
+
+// Fingerprint returns a short identifier that groups errors which took the
+// same path: the functions of the TraceError links and the types of the
+// other errors in the chain. Messages and line numbers are left out, so
+// errors differing only in their arguments share a fingerprint.
+func Fingerprint(err error) string {
+	if err == nil {
+		return ""
+	}
+	h := fnv.New64a()
+	fingerprintInto(h, err, make(map[error]struct{}))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// fingerprintInto hashes the chain of err and its branches. seen holds
+// the links on the path from the root; a link reached again through a
+// cyclic multi-error is hashed as a marker instead of being descended into.
+func fingerprintInto(h io.Writer, err error, seen map[error]struct{}) {
+	links := chain(err)
+	defer func() {
+		for _, link := range links {
+			if isPointer(link) {
+				delete(seen, link)
+			}
+		}
+	}()
+	for i, link := range links {
+		if isPointer(link) {
+			if _, dup := seen[link]; dup {
+				h.Write([]byte("(cycle)"))
+				links = links[:i]
+				return
+			}
+			seen[link] = struct{}{}
+		}
+		if e, ok := link.(*TraceError); ok {
+			function, _, _ := splitFrame(e.Frame)
+			h.Write([]byte(function))
+		} else {
+			h.Write([]byte(typeName(link)))
+		}
+		h.Write([]byte{0})
+		for _, branch := range branches(link) {
+			if branch != nil {
+				h.Write([]byte{'('})
+				fingerprintInto(h, branch, seen)
+				h.Write([]byte{')'})
+			}
+		}
+	}
+}
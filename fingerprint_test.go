@@ -0,0 +1,26 @@
+package trace_errors
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFingerprintCycle(t *testing.T) {
+	j := &cyclicJoin{}
+	j.errs = []error{errors.New("leaf"), j}
+	if Fingerprint(j) == "" {
+		t.Fatal("empty fingerprint")
+	}
+
+	shared := New("shared")
+	dag := errors.Join(shared, shared)
+	two := errors.Join(New("shared"), New("shared"))
+	if Fingerprint(dag) != Fingerprint(two) {
+		t.Error("an error reached twice without a cycle is treated as a cycle")
+	}
+}
+
+type cyclicJoin struct{ errs []error }
+
+func (j *cyclicJoin) Error() string   { return "cyclic join" }
+func (j *cyclicJoin) Unwrap() []error { return j.errs }
@@ -0,0 +1,257 @@
+package trace_errors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GELF limits on chunked UDP messages.
+const (
+	gelfMaxChunks = 128
+	// gelfChunkHeader is the size of the header of every chunk: magic,
+	// message id, sequence number and count.
+	gelfChunkHeader = 12
+	// gelfTruncated is the length messages are cut to when a payload does
+	// not fit in gelfMaxChunks chunks.
+	gelfTruncated = 8 << 10
+)
+
+// GELFOptions configures a GELFSink. The zero value uses the defaults
+// documented on the fields.
+type GELFOptions struct {
+	// Host is the host field. Empty means os.Hostname.
+	Host string
+	// Level is the syslog severity of the messages, 0 to 7. Nil means 3,
+	// error; it is a pointer so that 0, emergency, can be chosen.
+	Level *int
+	// ChunkSize is the largest UDP datagram sent; bigger payloads are
+	// gzip-compressed and, if still too big, chunked. It must leave room
+	// for data after the 12-byte chunk header. Zero means 1420.
+	ChunkSize int
+	// Timeout bounds dialing and every write. Zero means 5 seconds.
+	Timeout time.Duration
+}
+
+// GELFSink sends errors to Graylog as GELF 1.1 messages, over UDP with
+// compression and chunking or over TCP with null-byte framing.
+//
+// The message chain is the short_message, the text rendering with stack
+// the full_message, the innermost frame goes to _file, _line and
+// _function, and every field of the chain to an additional field named
+// after it with a leading underscore. Numeric field values are sent as
+// numbers and all others as text.
+type GELFSink struct {
+	opts  GELFOptions
+	level int
+	udp   bool
+	conn  *sinkConn
+}
+
+// NewGELFSink connects to the GELF input at addr on network, "udp" or
+// "tcp". It fails if an option is out of range.
+func NewGELFSink(network, addr string, opts GELFOptions) (*GELFSink, error) {
+	level := 3
+	if opts.Level != nil {
+		level = *opts.Level
+	}
+	if level < 0 || level > 7 {
+		return nil, fmt.Errorf("trace_errors: GELF level %d is not between 0 and 7", level)
+	}
+	if opts.ChunkSize != 0 && opts.ChunkSize <= gelfChunkHeader {
+		return nil, fmt.Errorf("trace_errors: GELF ChunkSize %d leaves no room after the %d-byte chunk header", opts.ChunkSize, gelfChunkHeader)
+	}
+	if opts.Host == "" {
+		opts.Host, _ = os.Hostname()
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = 1420
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	conn, err := dialSink(network, addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &GELFSink{
+		opts:  opts,
+		level: level,
+		udp:   strings.HasPrefix(network, "udp"),
+		conn:  conn,
+	}, nil
+}
+
+// Send implements Sink. A failed write is retried once on a new
+// connection, which may deliver the message twice.
+func (s *GELFSink) Send(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := s.message(err, time.Now())
+	var packets [][]byte
+	if s.udp {
+		var perr error
+		packets, perr = s.udpPackets(msg)
+		if errors.Is(perr, errGELFTooLarge) {
+			msg["short_message"] = truncateUTF8(msg["short_message"].(string), gelfTruncated)
+			msg["full_message"] = truncateUTF8(msg["full_message"].(string), gelfTruncated)
+			packets, perr = s.udpPackets(msg)
+		}
+		if perr != nil {
+			return perr
+		}
+	} else {
+		payload, merr := json.Marshal(msg)
+		if merr != nil {
+			return merr
+		}
+		packets = [][]byte{append(payload, 0)}
+	}
+	return s.conn.write(packets...)
+}
+
+// Close implements Sink.
+func (s *GELFSink) Close() error {
+	return s.conn.close()
+}
+
+// message builds the GELF document for err.
+func (s *GELFSink) message(err error, now time.Time) map[string]interface{} {
+	var full strings.Builder
+	_ = renderText(&full, err, RenderOptions{Stack: true})
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          s.opts.Host,
+		"short_message": message(err),
+		"full_message":  full.String(),
+		"timestamp":     float64(now.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         s.level,
+		"_fingerprint":  Fingerprint(err),
+	}
+	if msg["short_message"] == "" {
+		msg["short_message"] = typeName(err)
+	}
+	for k, v := range FieldsOf(err) {
+		if key := gelfField(k); key != "" && !gelfReserved[key] {
+			msg[key] = gelfValue(v)
+		}
+	}
+	if frame := innermostFrame(chain(err)); frame != "" {
+		function, file, line := splitFrame(frame)
+		msg["_function"] = function
+		msg["_file"] = file
+		msg["_line"] = line
+	}
+	return msg
+}
+
+// gelfField returns the additional field name for key, or "" if key
+// cannot be sent. GELF reserves _id and allows only [\w.-] in names.
+func gelfField(key string) string {
+	key = "_" + strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, key)
+	if key == "_" || key == "_id" {
+		return ""
+	}
+	return key
+}
+
+// gelfReserved holds the additional fields set by GELFSink itself, which
+// error fields of the same name do not overwrite.
+var gelfReserved = map[string]bool{
+	"_fingerprint": true,
+	"_function":    true,
+	"_file":        true,
+	"_line":        true,
+}
+
+// gelfValue returns v as GELF allows additional field values: numbers as
+// they are and everything else as its fmt.Sprint text.
+func gelfValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return n
+	case float32:
+		if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+			return fmt.Sprint(n)
+		}
+		return n
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return fmt.Sprint(n)
+		}
+		return n
+	}
+	return fmt.Sprint(v)
+}
+
+var errGELFTooLarge = errors.New("trace_errors: GELF message needs more than 128 chunks")
+
+// udpPackets encodes msg as one datagram, gzip-compressing and chunking it
+// when it exceeds the chunk size.
+func (s *GELFSink) udpPackets(msg map[string]interface{}) ([][]byte, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) <= s.opts.ChunkSize {
+		return [][]byte{payload}, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	payload = buf.Bytes()
+	if len(payload) <= s.opts.ChunkSize {
+		return [][]byte{payload}, nil
+	}
+
+	size := s.opts.ChunkSize - gelfChunkHeader
+	count := (len(payload) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, errGELFTooLarge
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	packets := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		p := make([]byte, 0, gelfChunkHeader+end-i*size)
+		p = append(p, 0x1e, 0x0f)
+		p = append(p, id[:]...)
+		p = append(p, byte(i), byte(count))
+		packets = append(packets, append(p, payload[i*size:end]...))
+	}
+	return packets, nil
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "... (truncated, " + strconv.Itoa(len(s)) + " bytes)"
+}
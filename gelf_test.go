@@ -0,0 +1,221 @@
+package trace_errors
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGELFFields(t *testing.T) {
+	pc := listenUDP(t)
+	s, err := NewGELFSink("udp", pc.LocalAddr().String(), GELFOptions{Host: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	type point struct{ X, Y int }
+	err = WithFields(New("failed"),
+		Field{Key: "count", Value: 3},
+		Field{Key: "ratio", Value: 0.5},
+		Field{Key: "point", Value: point{1, 2}},
+		Field{Key: "ids", Value: []string{"a", "b"}},
+		Field{Key: "file", Value: "upload.txt"},
+		Field{Key: "fingerprint", Value: "mine"},
+	)
+	if serr := s.Send(err); serr != nil {
+		t.Fatal(serr)
+	}
+	msg := readGELF(t, pc)
+
+	want := map[string]interface{}{
+		"short_message": "failed",
+		"host":          "test",
+		"_count":        3.0,
+		"_ratio":        0.5,
+		"_point":        "{1 2}",
+		"_ids":          "[a b]",
+		"_fingerprint":  Fingerprint(err),
+		"_function":     "github.com/apepenkov/trace_errors.TestGELFFields",
+	}
+	for k, v := range want {
+		if msg[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, msg[k], v)
+		}
+	}
+	if file, _ := msg["_file"].(string); !strings.HasSuffix(file, "gelf_test.go") {
+		t.Errorf("_file = %#v, want the frame's file", msg["_file"])
+	}
+}
+
+func TestGELFChunked(t *testing.T) {
+	pc := listenUDP(t)
+	s, err := NewGELFSink("udp", pc.LocalAddr().String(), GELFOptions{ChunkSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Random-looking text so that gzip alone does not make it fit.
+	var b strings.Builder
+	for i := 0; b.Len() < 4000; i++ {
+		b.WriteString(Fingerprint(Newf("%d", i)))
+		b.WriteString(time.Duration(i * 7919).String())
+	}
+	long := b.String()
+	if serr := s.Send(New(long)); serr != nil {
+		t.Fatal(serr)
+	}
+	if msg := readGELF(t, pc); msg["short_message"] != long {
+		t.Errorf("short_message has %d bytes, want %d", len(msg["short_message"].(string)), len(long))
+	}
+}
+
+func TestGELFTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s, err := NewGELFSink("tcp", ln.Addr().String(), GELFOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, m := range []string{"first", "second"} {
+		if serr := s.Send(New(m)); serr != nil {
+			t.Fatal(serr)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"first", "second"} {
+		frame, err := r.ReadBytes(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(frame[:len(frame)-1], &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg["short_message"] != want {
+			t.Errorf("short_message = %v, want %s", msg["short_message"], want)
+		}
+	}
+}
+
+func TestGELFOptions(t *testing.T) {
+	pc := listenUDP(t)
+	addr := pc.LocalAddr().String()
+
+	zero, big, negative := 0, 8, -1
+	for _, opts := range []GELFOptions{
+		{Level: &big},
+		{Level: &negative},
+		{ChunkSize: -1},
+		{ChunkSize: 12},
+	} {
+		if s, err := NewGELFSink("udp", addr, opts); err == nil {
+			s.Close()
+			t.Errorf("NewGELFSink(%+v) succeeded", opts)
+		}
+	}
+
+	s, err := NewGELFSink("udp", addr, GELFOptions{Level: &zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if serr := s.Send(New("down")); serr != nil {
+		t.Fatal(serr)
+	}
+	if msg := readGELF(t, pc); msg["level"] != 0.0 {
+		t.Errorf("level = %#v, want 0", msg["level"])
+	}
+
+	// The smallest chunk size carries one byte per chunk: too few for the
+	// message, which is an error and not a panic.
+	tiny, err := NewGELFSink("udp", addr, GELFOptions{ChunkSize: 13})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tiny.Close()
+	if serr := tiny.Send(New("down")); serr == nil {
+		t.Error("Send with 1-byte chunks succeeded")
+	}
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// readGELF reads one GELF message from pc, reassembling chunks and
+// decompressing it as needed.
+func readGELF(t *testing.T, pc net.PacketConn) map[string]interface{} {
+	t.Helper()
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var chunks [][]byte
+	var payload []byte
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := append([]byte(nil), buf[:n]...)
+		if !bytes.HasPrefix(p, []byte{0x1e, 0x0f}) {
+			payload = p
+			break
+		}
+		seq, count := int(p[10]), int(p[11])
+		if chunks == nil {
+			chunks = make([][]byte, count)
+		}
+		chunks[seq] = p[12:]
+		if complete(chunks) {
+			payload = bytes.Join(chunks, nil)
+			break
+		}
+	}
+	if bytes.HasPrefix(payload, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload, err = io.ReadAll(zr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func complete(chunks [][]byte) bool {
+	for _, c := range chunks {
+		if c == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -1 +1,3 @@
 module github.com/apepenkov/trace_errors
+
+go 1.20
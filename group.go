@@ -0,0 +1,108 @@
+package trace_errors
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Field keys set on the errors returned by Group.Do.
+const (
+	SingleflightKeyField    = "singleflight.key"
+	SingleflightSharedField = "singleflight.shared"
+)
+
+// Group deduplicates concurrent calls with the same key, in the manner of
+// golang.org/x/sync/singleflight. Unlike singleflight, every caller gets its
+// own TraceError wrapping the shared error, so each trace shows the frame
+// of the caller it was returned to.
+//
+// The zero value is ready to use.
+type Group struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+type call struct {
+	wg   sync.WaitGroup
+	val  interface{}
+	err  error
+	dups int
+}
+
+// Do executes fn once for all concurrent callers using the same key and
+// returns its results to each of them. shared reports whether the result
+// was given to more than one caller. A non-nil error is wrapped in a
+// TraceError carrying the caller's frame; when shared, its message says
+// so and its SingleflightSharedField is true.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	c, ok := g.m[key]
+	if ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+	} else {
+		c = new(call)
+		c.wg.Add(1)
+		g.m[key] = c
+		g.mu.Unlock()
+		g.doCall(c, key, fn)
+	}
+
+	g.mu.Lock()
+	shared = c.dups > 0
+	g.mu.Unlock()
+	if c.err == nil {
+		return c.val, nil, shared
+	}
+	te := &TraceError{
+		Err:   c.err,
+		Frame: captureStackFrame(),
+		Fields: map[string]interface{}{
+			SingleflightKeyField:    key,
+			SingleflightSharedField: shared,
+		},
+	}
+	if shared {
+		te.template = "shared result of call %q"
+		te.Msg = fmt.Sprintf(te.template, key)
+	}
+	return c.val, created(te), shared
+}
+
+// Forget makes the next call to Do for key execute fn instead of waiting
+// for an earlier call still in flight.
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+}
+
+// doCall runs fn and releases the waiters. If fn panics, the waiters get
+// an error describing the panic and the panic continues in the caller. If
+// fn calls runtime.Goexit, the waiters get an error saying so and the
+// caller's goroutine exits.
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	defer func() {
+		if !normalReturn {
+			if r := recover(); r != nil {
+				c.err = fmt.Errorf("singleflight: call %q panicked: %v", key, r)
+				defer panic(r)
+			} else {
+				c.err = fmt.Errorf("singleflight: call %q called runtime.Goexit", key)
+			}
+		}
+		g.mu.Lock()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+	normalReturn = true
+}
@@ -0,0 +1,153 @@
+package trace_errors
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// waitForDups waits until n callers wait on the call for key.
+func waitForDups(g *Group, key string, n int) {
+	for {
+		g.mu.Lock()
+		c := g.m[key]
+		ready := c != nil && c.dups >= n
+		g.mu.Unlock()
+		if ready {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+// startCall calls g.Do for key in a new goroutine with a fn that blocks
+// until release is closed and then runs then. It returns once fn runs.
+func startCall(g *Group, key string, release chan struct{}, then func() (interface{}, error)) {
+	entered := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		g.Do(key, func() (interface{}, error) {
+			close(entered)
+			<-release
+			return then()
+		})
+	}()
+	<-entered
+}
+
+func TestGroupOwnResult(t *testing.T) {
+	var g Group
+	cause := errors.New("not found")
+	v, err, shared := g.Do("k", func() (interface{}, error) { return 1, cause })
+	if v != 1 || shared {
+		t.Errorf("Do = %v, shared %v; want 1, false", v, shared)
+	}
+	te, ok := err.(*TraceError)
+	if !ok || te.Err != cause || te.Msg != "" {
+		t.Fatalf("err = %#v, want a TraceError wrapping the cause", err)
+	}
+	if !strings.Contains(te.Frame, "TestGroupOwnResult") {
+		t.Errorf("frame %q is not the caller's", te.Frame)
+	}
+	if te.Fields[SingleflightKeyField] != "k" || te.Fields[SingleflightSharedField] != false {
+		t.Errorf("fields = %v", te.Fields)
+	}
+
+	if _, err, _ := g.Do("k", func() (interface{}, error) { return 2, nil }); err != nil {
+		t.Errorf("successful call returned %v", err)
+	}
+}
+
+func TestGroupSharedResult(t *testing.T) {
+	var g Group
+	cause := errors.New("timeout")
+	release := make(chan struct{})
+	startCall(&g, "k", release, func() (interface{}, error) { return nil, cause })
+
+	done := make(chan error)
+	go func() {
+		_, err, shared := g.Do("k", func() (interface{}, error) {
+			t.Error("fn ran for a caller waiting on the call in flight")
+			return nil, nil
+		})
+		if !shared {
+			t.Error("waiter not told the result is shared")
+		}
+		done <- err
+	}()
+	waitForDups(&g, "k", 1)
+	close(release)
+	err := <-done
+
+	te, ok := err.(*TraceError)
+	if !ok || !errors.Is(err, cause) {
+		t.Fatalf("err = %#v, want a TraceError wrapping the cause", err)
+	}
+	if te.Msg != `shared result of call "k"` {
+		t.Errorf("Msg = %q", te.Msg)
+	}
+	if te.Fields[SingleflightSharedField] != true {
+		t.Errorf("fields = %v", te.Fields)
+	}
+	if !strings.Contains(te.Frame, "TestGroupSharedResult.func") {
+		t.Errorf("frame %q is not the waiter's", te.Frame)
+	}
+}
+
+func TestGroupForget(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	defer close(release)
+	startCall(&g, "k", release, func() (interface{}, error) { return "first", nil })
+
+	g.Forget("k")
+	v, err, shared := g.Do("k", func() (interface{}, error) { return "second", nil })
+	if v != "second" || err != nil || shared {
+		t.Errorf("Do after Forget = %v, %v, %v; want its own call", v, err, shared)
+	}
+}
+
+func TestGroupPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	startCall(&g, "k", release, func() (interface{}, error) { panic("boom") })
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var err error
+	go func() {
+		defer wg.Done()
+		_, err, _ = g.Do("k", func() (interface{}, error) { return nil, nil })
+	}()
+	waitForDups(&g, "k", 1)
+	close(release)
+	wg.Wait()
+	if err == nil || !strings.Contains(err.Error(), `call "k" panicked: boom`) {
+		t.Errorf("waiter got %v, want the panic", err)
+	}
+}
+
+func TestGroupGoexit(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	startCall(&g, "k", release, func() (interface{}, error) {
+		runtime.Goexit()
+		return nil, nil
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var err error
+	go func() {
+		defer wg.Done()
+		_, err, _ = g.Do("k", func() (interface{}, error) { return nil, nil })
+	}()
+	waitForDups(&g, "k", 1)
+	close(release)
+	wg.Wait()
+	if err == nil || !strings.Contains(err.Error(), "runtime.Goexit") {
+		t.Errorf("waiter got %v, want an error naming runtime.Goexit", err)
+	}
+}
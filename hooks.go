@@ -0,0 +1,64 @@
+package trace_errors
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Hook is called with every TraceError created by the package's
+// constructors, on the goroutine that created it. Hooks must be safe for
+// concurrent use and should return quickly.
+type Hook func(err *TraceError)
+
+type hookEntry struct {
+	h Hook
+}
+
+var (
+	hooksMu sync.Mutex
+	// hooks holds a []*hookEntry that is replaced, never modified.
+	hooks atomic.Value
+)
+
+// AddHook registers h and returns a function that removes it again.
+func AddHook(h Hook) (remove func()) {
+	entry := &hookEntry{h}
+	hooksMu.Lock()
+	current, _ := hooks.Load().([]*hookEntry)
+	hooks.Store(append(current[:len(current):len(current)], entry))
+	hooksMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			hooksMu.Lock()
+			defer hooksMu.Unlock()
+			current, _ := hooks.Load().([]*hookEntry)
+			next := make([]*hookEntry, 0, len(current))
+			for _, e := range current {
+				if e != entry {
+					next = append(next, e)
+				}
+			}
+			hooks.Store(next)
+		})
+	}
+}
+
+// created finishes the construction of e and runs the registered hooks.
+// It must be called directly by the exported constructors, so that the
+// stack it captures starts at their caller.
+func created(e *TraceError) error {
+	if atomic.LoadInt32(&fullStacks) != 0 {
+		e.stack = captureStack(2, e.Err)
+	}
+	if atomic.LoadInt32(&snapshotsOn) != 0 && !hasSnapshot(e.Err) {
+		e.Runtime = runtimeSnapshot()
+	}
+	checkFields(e)
+	current, _ := hooks.Load().([]*hookEntry)
+	for _, entry := range current {
+		entry.h(e)
+	}
+	return e
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package trace_errors
+
+// openFiles returns -1: counting open files is only supported on Linux.
+func openFiles() int {
+	return -1
+}
@@ -0,0 +1,277 @@
+package trace_errors
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// profileDirPrefix starts the name of every directory written by a
+// ProfileTrigger, so that the disk cap only ever removes those.
+const profileDirPrefix = "trace_errors-"
+
+// ProfileTriggerOptions configures StartProfileTrigger. Apart from Dir, the
+// zero value uses the defaults documented on the fields.
+type ProfileTriggerOptions struct {
+	// Dir is the directory profiles are written to, one subdirectory per
+	// capture.
+	Dir string
+	// Filter, if set, restricts the errors that are counted.
+	Filter *Filter
+	// ByCode counts errors by code instead of by fingerprint. Errors
+	// without a code are then ignored.
+	ByCode bool
+	// Threshold is the number of errors with the same fingerprint or code
+	// within Window that triggers a capture. Zero means 100.
+	Threshold int
+	// Window is the period errors are counted over. Zero means a minute.
+	Window time.Duration
+	// CPUDuration is how long the CPU profile runs. Zero means 10 seconds.
+	CPUDuration time.Duration
+	// Cooldown is the minimum time between two captures. Zero means 10
+	// minutes.
+	Cooldown time.Duration
+	// MaxDiskBytes caps the size of the captures kept in Dir; the oldest
+	// are removed to stay below it. Zero means 100 MiB.
+	MaxDiskBytes int64
+	// OnCapture, if set, is called after each capture with its directory
+	// and the error that prevented part of it, if any.
+	OnCapture func(dir string, err error)
+}
+
+// ProfileTrigger watches the TraceErrors being created and, when errors
+// with the same fingerprint or code spike, writes CPU, heap and goroutine
+// profiles for later forensics.
+type ProfileTrigger struct {
+	opts   ProfileTriggerOptions
+	remove func()
+	wg     sync.WaitGroup
+	done   chan struct{} // closed by Stop
+
+	mu        sync.Mutex
+	counts    map[string]*triggerCount
+	capturing bool
+	last      time.Time
+	stopped   bool
+}
+
+type triggerCount struct {
+	start time.Time
+	n     int
+}
+
+// StartProfileTrigger starts watching errors. It creates opts.Dir if
+// needed.
+func StartProfileTrigger(opts ProfileTriggerOptions) (*ProfileTrigger, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("trace_errors: profile trigger needs a directory")
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 100
+	}
+	if opts.Window <= 0 {
+		opts.Window = time.Minute
+	}
+	if opts.CPUDuration <= 0 {
+		opts.CPUDuration = 10 * time.Second
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 10 * time.Minute
+	}
+	if opts.MaxDiskBytes <= 0 {
+		opts.MaxDiskBytes = 100 << 20
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	t := &ProfileTrigger{opts: opts, counts: make(map[string]*triggerCount), done: make(chan struct{})}
+	t.remove = AddHook(t.observe)
+	return t, nil
+}
+
+// Stop stops watching errors and waits for a capture in progress, cutting
+// its CPU profile short.
+func (t *ProfileTrigger) Stop() {
+	t.remove()
+	t.mu.Lock()
+	if !t.stopped {
+		t.stopped = true
+		close(t.done)
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+}
+
+func (t *ProfileTrigger) observe(e *TraceError) {
+	if t.opts.Filter != nil && !t.opts.Filter.Match(e) {
+		return
+	}
+	key := Fingerprint(e)
+	if t.opts.ByCode {
+		if key = Code(e); key == "" {
+			return
+		}
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.counts[key]
+	if !ok || now.Sub(c.start) > t.opts.Window {
+		if len(t.counts) > 10000 {
+			t.counts = make(map[string]*triggerCount)
+		}
+		c = &triggerCount{start: now}
+		t.counts[key] = c
+	}
+	c.n++
+	if c.n < t.opts.Threshold || t.capturing || t.stopped || (!t.last.IsZero() && now.Sub(t.last) < t.opts.Cooldown) {
+		return
+	}
+	t.capturing = true
+	t.last = now
+	delete(t.counts, key)
+	reason := fmt.Sprintf("%d errors with %s %s within %s\nexample: %s\n", c.n, t.keyName(), key, t.opts.Window, message(e))
+	trace := StackTrace(e)
+	t.wg.Add(1)
+	go t.capture(key, reason+trace+"\n")
+}
+
+func (t *ProfileTrigger) keyName() string {
+	if t.opts.ByCode {
+		return "code"
+	}
+	return "fingerprint"
+}
+
+// capture writes the profiles into a new subdirectory of Dir.
+func (t *ProfileTrigger) capture(key, reason string) {
+	defer t.wg.Done()
+	defer func() {
+		t.mu.Lock()
+		t.capturing = false
+		t.mu.Unlock()
+	}()
+
+	name := profileDirPrefix + time.Now().UTC().Format("20060102T150405.000") + "-" + sanitizeFileName(key)
+	dir := filepath.Join(t.opts.Dir, name)
+	var errs []error
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.report(dir, err)
+		return
+	}
+	errs = append(errs, os.WriteFile(filepath.Join(dir, "reason.txt"), []byte(reason), 0o644))
+	errs = append(errs, writeProfile(dir, "goroutine"), writeProfile(dir, "heap"))
+	errs = append(errs, t.writeCPUProfile(dir))
+	errs = append(errs, t.enforceDiskCap(name))
+	t.report(dir, errors.Join(errs...))
+}
+
+func (t *ProfileTrigger) report(dir string, err error) {
+	if t.opts.OnCapture != nil {
+		t.opts.OnCapture(dir, err)
+	}
+}
+
+func writeProfile(dir, name string) error {
+	f, err := os.Create(filepath.Join(dir, name+".pprof"))
+	if err != nil {
+		return err
+	}
+	werr := pprof.Lookup(name).WriteTo(f, 0)
+	if cerr := f.Close(); werr == nil {
+		werr = cerr
+	}
+	return werr
+}
+
+func (t *ProfileTrigger) writeCPUProfile(dir string) error {
+	f, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := pprof.StartCPUProfile(f); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	timer := time.NewTimer(t.opts.CPUDuration)
+	select {
+	case <-timer.C:
+	case <-t.done:
+		timer.Stop()
+	}
+	pprof.StopCPUProfile()
+	return nil
+}
+
+// enforceDiskCap removes the oldest captures until those in Dir fit in
+// MaxDiskBytes. The capture named keep is removed only if it alone does
+// not fit.
+func (t *ProfileTrigger) enforceDiskCap(keep string) error {
+	entries, err := os.ReadDir(t.opts.Dir)
+	if err != nil {
+		return err
+	}
+	type capture struct {
+		name string
+		size int64
+	}
+	var captures []capture
+	var total int64
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), profileDirPrefix) {
+			continue
+		}
+		size := dirSize(filepath.Join(t.opts.Dir, e.Name()))
+		captures = append(captures, capture{e.Name(), size})
+		total += size
+	}
+	// Names start with the capture time, so they sort oldest first.
+	sort.Slice(captures, func(i, j int) bool { return captures[i].name < captures[j].name })
+	for _, c := range captures {
+		if total <= t.opts.MaxDiskBytes {
+			break
+		}
+		if c.name == keep && total-c.size > 0 {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(t.opts.Dir, c.name)); err != nil {
+			return err
+		}
+		total -= c.size
+	}
+	return nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, ierr := d.Info(); ierr == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func sanitizeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+	if len(s) > 64 {
+		s = s[:64]
+	}
+	return s
+}
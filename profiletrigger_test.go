@@ -0,0 +1,147 @@
+package trace_errors
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type captureReport struct {
+	dir string
+	err error
+}
+
+func startTestTrigger(t *testing.T, opts ProfileTriggerOptions) (*ProfileTrigger, chan captureReport) {
+	t.Helper()
+	reports := make(chan captureReport, 10)
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	opts.Threshold = 3
+	opts.OnCapture = func(dir string, err error) { reports <- captureReport{dir, err} }
+	trigger, err := StartProfileTrigger(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(trigger.Stop)
+	return trigger, reports
+}
+
+// spike creates n errors with the same fingerprint.
+func spike(n int) {
+	for i := 0; i < n; i++ {
+		_ = New("spike")
+	}
+}
+
+func waitReport(t *testing.T, reports chan captureReport) captureReport {
+	t.Helper()
+	select {
+	case r := <-reports:
+		return r
+	case <-time.After(10 * time.Second):
+		t.Fatal("no capture")
+	}
+	return captureReport{}
+}
+
+func TestProfileTriggerCapture(t *testing.T) {
+	_, reports := startTestTrigger(t, ProfileTriggerOptions{CPUDuration: 10 * time.Millisecond})
+	spike(2)
+	select {
+	case r := <-reports:
+		t.Fatalf("captured below the threshold: %v", r.dir)
+	case <-time.After(50 * time.Millisecond):
+	}
+	spike(1)
+	r := waitReport(t, reports)
+	if r.err != nil {
+		t.Logf("capture error: %v", r.err) // the CPU profile fails under -cpuprofile
+	}
+	if !strings.HasPrefix(filepath.Base(r.dir), profileDirPrefix) {
+		t.Errorf("capture directory %q lacks the prefix", r.dir)
+	}
+	for _, name := range []string{"reason.txt", "goroutine.pprof", "heap.pprof"} {
+		if _, err := os.Stat(filepath.Join(r.dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	reason, err := os.ReadFile(filepath.Join(r.dir, "reason.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(reason), "3 errors with fingerprint ") || !strings.Contains(string(reason), "example: spike") {
+		t.Errorf("reason.txt = %q", reason)
+	}
+}
+
+func TestProfileTriggerCooldown(t *testing.T) {
+	_, reports := startTestTrigger(t, ProfileTriggerOptions{CPUDuration: time.Millisecond, Cooldown: time.Hour})
+	spike(3)
+	waitReport(t, reports)
+	spike(10)
+	select {
+	case r := <-reports:
+		t.Errorf("captured again within the cooldown: %v", r.dir)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProfileTriggerStopInterruptsCPUProfile(t *testing.T) {
+	trigger, reports := startTestTrigger(t, ProfileTriggerOptions{CPUDuration: time.Hour})
+	spike(3)
+	// Wait for the CPU profile to start.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		matches, _ := filepath.Glob(filepath.Join(trigger.opts.Dir, profileDirPrefix+"*", "cpu.pprof"))
+		if len(matches) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("CPU profile not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	trigger.Stop()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Stop took %s", d)
+	}
+	waitReport(t, reports)
+	trigger.Stop() // a second Stop does nothing
+}
+
+func TestProfileTriggerDiskCap(t *testing.T) {
+	dir := t.TempDir()
+	big := make([]byte, 1<<20)
+	for _, name := range []string{profileDirPrefix + "20000101T000000.000-first", profileDirPrefix + "20000102T000000.000-second", "unrelated"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "data"), big, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, reports := startTestTrigger(t, ProfileTriggerOptions{Dir: dir, CPUDuration: time.Millisecond, MaxDiskBytes: 3 << 19})
+	spike(3)
+	r := waitReport(t, reports)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	// 1.5 MiB holds one old capture and the new one, but not both old ones:
+	// the oldest goes. Directories without the prefix are never touched.
+	want := []string{profileDirPrefix + "20000102T000000.000-second", filepath.Base(r.dir), "unrelated"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("directory holds %v, want %v", names, want)
+	}
+}
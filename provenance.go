@@ -0,0 +1,73 @@
+package trace_errors
+
+import "context"
+
+// Provenance records where a job was submitted to an in-process queue, so
+// that errors returned by the worker running it can point back there.
+//
+//	p := trace_errors.CaptureProvenance(JobID.Of(id))
+//	queue <- job{run: run, provenance: p}
+//
+//	// in the worker
+//	if err := j.run(); err != nil {
+//		return j.provenance.Wrap(err)
+//	}
+type Provenance struct {
+	Frame  string                 `json:"frame"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+type provenanceKey struct{}
+
+// CaptureProvenance records the frame of its caller and the given fields.
+func CaptureProvenance(fields ...Field) *Provenance {
+	p := &Provenance{Frame: captureStackFrame()}
+	if len(fields) > 0 {
+		p.Fields = make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			p.Fields[f.Key] = f.Value
+		}
+	}
+	return p
+}
+
+// Wrap wraps an error returned by the job with the worker's stack frame,
+// the fields of p and p itself. StackTrace shows where the job was
+// enqueued beneath the worker's frames. A nil p wraps err like WrapTrace,
+// so jobs enqueued without a Provenance need no special case.
+func (p *Provenance) Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	if p == nil {
+		return created(&TraceError{
+			Err:   err,
+			Frame: captureStackFrame(),
+		})
+	}
+	var fields map[string]interface{}
+	if len(p.Fields) > 0 {
+		fields = make(map[string]interface{}, len(p.Fields))
+		for k, v := range p.Fields {
+			fields[k] = v
+		}
+	}
+	return created(&TraceError{
+		Err:      err,
+		Frame:    captureStackFrame(),
+		Fields:   fields,
+		Enqueued: p,
+	})
+}
+
+// WithProvenance returns a copy of ctx carrying p, for jobs that take a
+// context.
+func WithProvenance(ctx context.Context, p *Provenance) context.Context {
+	return context.WithValue(ctx, provenanceKey{}, p)
+}
+
+// ProvenanceFrom returns the Provenance carried by ctx, or nil.
+func ProvenanceFrom(ctx context.Context) *Provenance {
+	p, _ := ctx.Value(provenanceKey{}).(*Provenance)
+	return p
+}
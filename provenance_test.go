@@ -0,0 +1,39 @@
+package trace_errors
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProvenanceWrap(t *testing.T) {
+	p := CaptureProvenance(Field{Key: ItemField, Value: "job-1"})
+	err := p.Wrap(errors.New("failed"))
+	if !strings.Contains(StackTrace(err), "enqueued at:\n"+p.Frame) {
+		t.Errorf("StackTrace() does not show the enqueue site:\n%s", StackTrace(err))
+	}
+
+	err.(*TraceError).Fields[ItemField] = "changed"
+	if p.Fields[ItemField] != "job-1" {
+		t.Error("the error shares its fields with the Provenance")
+	}
+}
+
+func TestProvenanceWrapNil(t *testing.T) {
+	p := ProvenanceFrom(context.Background())
+	err := p.Wrap(errors.New("failed"))
+	e, ok := err.(*TraceError)
+	if !ok {
+		t.Fatalf("got %T, want *TraceError", err)
+	}
+	if e.Enqueued != nil || e.Fields != nil {
+		t.Errorf("got Enqueued %v and Fields %v, want neither", e.Enqueued, e.Fields)
+	}
+	if !strings.Contains(e.Frame, "TestProvenanceWrapNil") {
+		t.Errorf("Frame = %q, want the caller of Wrap", e.Frame)
+	}
+	if p.Wrap(nil) != nil {
+		t.Error("Wrap(nil) != nil")
+	}
+}
@@ -0,0 +1,313 @@
+package trace_errors
+
+import (
+	"bufio"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StackFrame is one frame of a stack trace, from Go or from a service
+// written in another language.
+type StackFrame struct {
+	Language string `json:"language"`
+	Module   string `json:"module,omitempty"`
+	Function string `json:"function"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+// String formats the frame the way captured Go frames are formatted.
+func (f StackFrame) String() string {
+	function := f.Function
+	if f.Module != "" && f.Language != "go" {
+		function = f.Module + "." + f.Function
+	}
+	if f.File == "" {
+		return function
+	}
+	if f.Line <= 0 {
+		return function + "\n\t" + f.File
+	}
+	return function + "\n\t" + f.File + ":" + strconv.Itoa(f.Line)
+}
+
+// ParseFrame parses a frame captured by this package into a StackFrame.
+func ParseFrame(frame string) StackFrame {
+	function, file, line := splitFrame(frame)
+	return StackFrame{
+		Language: "go",
+		Module:   goPackage(function),
+		Function: function,
+		File:     file,
+		Line:     line,
+	}
+}
+
+// goPackage returns the package path of a fully qualified Go function name.
+func goPackage(function string) string {
+	slash := strings.LastIndexByte(function, '/')
+	if dot := strings.IndexByte(function[slash+1:], '.'); dot >= 0 {
+		return function[:slash+1+dot]
+	}
+	return ""
+}
+
+// RemoteError is an error reported by another service together with the
+// stack trace that service sent back.
+type RemoteError struct {
+	Service  string       `json:"service,omitempty"`
+	Language string       `json:"language"`
+	Type     string       `json:"type,omitempty"`
+	Message  string       `json:"message"`
+	Frames   []StackFrame `json:"frames,omitempty"` // innermost first
+}
+
+// Error implements the error interface.
+func (e *RemoteError) Error() string {
+	var b strings.Builder
+	if e.Service != "" {
+		b.WriteString(e.Service)
+		b.WriteString(": ")
+	}
+	if e.Type != "" {
+		b.WriteString(e.Type)
+		if e.Message != "" {
+			b.WriteString(": ")
+		}
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// header returns the line introducing the remote frames in a stack trace.
+func (e *RemoteError) header() string {
+	service := e.Service
+	if service == "" {
+		service = "unknown"
+	}
+	return "remote service " + service + " (" + e.Language + "): " + strings.TrimPrefix(e.Error(), e.Service+": ")
+}
+
+// Frames returns the frames of the error chain, innermost first: the frames
+// of a RemoteError at the bottom of the chain followed by the frames of the
+// TraceError links.
+func Frames(err error) []StackFrame {
+	var frames []StackFrame
+	for _, link := range chain(err) {
+		e, ok := link.(*TraceError)
+		if !ok {
+			if remote := findRemote(link); remote != nil {
+				frames = append(append([]StackFrame(nil), remote.Frames...), frames...)
+			}
+			break
+		}
+		if e.Frame != "" {
+			frames = append([]StackFrame{ParseFrame(e.Frame)}, frames...)
+		}
+	}
+	return frames
+}
+
+// findRemote returns the first RemoteError in the chain of err. Unlike
+// errors.As it stops at cycles.
+func findRemote(err error) *RemoteError {
+	for _, link := range chain(err) {
+		if remote, ok := link.(*RemoteError); ok {
+			return remote
+		}
+	}
+	return nil
+}
+
+// ParseRemoteError extracts a Python traceback or a Java stack trace from a
+// response body of service. The body may be plain text or a JSON document
+// holding the trace in one of its string values.
+func ParseRemoteError(service, body string) (*RemoteError, bool) {
+	for _, text := range candidateTexts(body) {
+		remote, ok := ParsePythonTraceback(text)
+		if !ok {
+			remote, ok = ParseJavaStackTrace(text)
+		}
+		if ok {
+			remote.Service = service
+			return remote, true
+		}
+	}
+	return nil, false
+}
+
+// candidateTexts returns body followed by the string values of body when it
+// is a JSON document, in document order for arrays and key order for
+// objects, so that the same body always yields the same trace.
+func candidateTexts(body string) []string {
+	texts := []string{body}
+	trimmed := strings.TrimSpace(body)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return texts
+	}
+	var doc interface{}
+	if json.Unmarshal([]byte(trimmed), &doc) != nil {
+		return texts
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			texts = append(texts, v)
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(v[k])
+			}
+		}
+	}
+	walk(doc)
+	return texts
+}
+
+var pythonFrameRe = regexp.MustCompile(`^\s+File "([^"]*)", line (\d+), in (.+)$`)
+
+// ParsePythonTraceback parses the last traceback found in text, as printed
+// by the Python traceback module. With chained exceptions that is the
+// traceback of the exception raised last. A message spanning several lines
+// runs up to the next empty line.
+func ParsePythonTraceback(text string) (*RemoteError, bool) {
+	start := strings.LastIndex(text, "Traceback (most recent call last):")
+	if start < 0 {
+		return nil, false
+	}
+	remote := &RemoteError{Language: "python"}
+	sc := bufio.NewScanner(strings.NewReader(text[start:]))
+	sc.Buffer(nil, len(text)+1)
+	sc.Scan() // the "Traceback" line
+	for sc.Scan() {
+		line := sc.Text()
+		if m := pythonFrameRe.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			frame := StackFrame{
+				Language: "python",
+				Module:   pythonModule(m[1]),
+				Function: m[3],
+				File:     m[1],
+				Line:     n,
+			}
+			remote.Frames = append([]StackFrame{frame}, remote.Frames...)
+			continue
+		}
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		remote.Type, remote.Message = line, ""
+		if i := strings.Index(line, ": "); i >= 0 {
+			remote.Type, remote.Message = line[:i], line[i+2:]
+			for sc.Scan() && sc.Text() != "" {
+				remote.Message += "\n" + sc.Text()
+			}
+		}
+		break
+	}
+	if remote.Type == "" && len(remote.Frames) == 0 {
+		return nil, false
+	}
+	return remote, true
+}
+
+// pythonModule derives a module name from the path of a Python source file.
+func pythonModule(file string) string {
+	if i := strings.LastIndexAny(file, `/\`); i >= 0 {
+		file = file[i+1:]
+	}
+	return strings.TrimSuffix(file, ".py")
+}
+
+var (
+	javaFrameRe = regexp.MustCompile(`^\s+at\s+(?:[\w.$-]+/)*([\w.$<>]+)\.([\w$<>]+)\(([^:)]*)(?::(\d+))?\)`)
+	javaMoreRe  = regexp.MustCompile(`^\s+\.\.\. (\d+) more`)
+	// javaThreadRe matches the prefix the default handler for uncaught
+	// exceptions prints before the exception.
+	javaThreadRe = regexp.MustCompile(`^Exception in thread "[^"]*" `)
+)
+
+// ParseJavaStackTrace parses the first stack trace found in text, as printed
+// by Throwable.printStackTrace. When the trace has "Caused by" sections the
+// root cause, the last of them, is returned: it names the exception where
+// the failure started and its frames, completed by the frames it shares
+// with the exceptions wrapping it, reach from there to the thread's entry
+// point. Suppressed exceptions are ignored.
+func ParseJavaStackTrace(text string) (*RemoteError, bool) {
+	lines := strings.Split(text, "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		header := strings.TrimRight(lines[i], "\r")
+		if header == "" || header[0] == ' ' || header[0] == '\t' || !javaFrameRe.MatchString(lines[i+1]) {
+			continue
+		}
+		remote := javaException(javaThreadRe.ReplaceAllString(header, ""))
+		var j int
+		remote.Frames, j = javaFrames(lines, i+1, nil)
+		for j < len(lines) {
+			line := strings.TrimRight(lines[j], "\r")
+			if cause := strings.TrimPrefix(line, "Caused by: "); cause != line {
+				enclosing := remote.Frames
+				remote = javaException(cause)
+				remote.Frames, j = javaFrames(lines, j+1, enclosing)
+				continue
+			}
+			if line == "" || line[0] != ' ' && line[0] != '\t' {
+				break
+			}
+			j++ // a line of a suppressed exception
+		}
+		return remote, true
+	}
+	return nil, false
+}
+
+// javaException parses the line naming an exception and its message.
+func javaException(header string) *RemoteError {
+	remote := &RemoteError{Language: "java", Type: header}
+	if j := strings.Index(header, ": "); j >= 0 {
+		remote.Type, remote.Message = header[:j], header[j+2:]
+	}
+	return remote
+}
+
+// javaFrames parses the frames starting at lines[i] and returns them with
+// the index of the first line after them. A closing "... n more" line
+// stands for the last n frames of enclosing.
+func javaFrames(lines []string, i int, enclosing []StackFrame) ([]StackFrame, int) {
+	var frames []StackFrame
+	for ; i < len(lines); i++ {
+		if m := javaFrameRe.FindStringSubmatch(lines[i]); m != nil {
+			n, _ := strconv.Atoi(m[4])
+			frames = append(frames, StackFrame{
+				Language: "java",
+				Module:   m[1],
+				Function: m[2],
+				File:     m[3],
+				Line:     n,
+			})
+			continue
+		}
+		if m := javaMoreRe.FindStringSubmatch(lines[i]); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			if n > len(enclosing) {
+				n = len(enclosing)
+			}
+			frames = append(frames, enclosing[len(enclosing)-n:]...)
+			i++
+		}
+		break
+	}
+	return frames, i
+}
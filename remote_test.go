@@ -0,0 +1,160 @@
+package trace_errors
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const javaTrace = `Exception in thread "main" java.lang.IllegalStateException: could not load order
+	at com.example.OrderService.load(OrderService.java:42)
+	at com.example.Main.main(Main.java:10)
+	Suppressed: java.io.IOException: close failed
+		at com.example.Conn.close(Conn.java:7)
+		... 2 more
+Caused by: java.sql.SQLException: connection refused
+	at com.example.Db.query(Db.java:88)
+	at com.example.OrderService.load(OrderService.java:40)
+	... 1 more
+Caused by: java.net.ConnectException: Connection refused
+	at java.base/sun.nio.ch.Net.connect0(Native Method)
+	at com.example.Db.connect(Db.java:12)
+	... 2 more
+`
+
+func TestParseJavaStackTrace(t *testing.T) {
+	remote, ok := ParseJavaStackTrace(javaTrace)
+	if !ok {
+		t.Fatal("no stack trace found")
+	}
+	if remote.Type != "java.net.ConnectException" || remote.Message != "Connection refused" {
+		t.Errorf("got %s: %s, want the root cause", remote.Type, remote.Message)
+	}
+	var got []string
+	for _, f := range remote.Frames {
+		got = append(got, f.Module+"."+f.Function)
+	}
+	want := []string{
+		"sun.nio.ch.Net.connect0",
+		"com.example.Db.connect",
+		"com.example.OrderService.load",
+		"com.example.Main.main",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("frames = %v, want %v", got, want)
+	}
+}
+
+func TestParseJavaStackTraceThreadPrefix(t *testing.T) {
+	remote, ok := ParseJavaStackTrace("Exception in thread \"worker-1\" java.lang.NullPointerException\n\tat com.example.Main.main(Main.java:3)\n")
+	if !ok {
+		t.Fatal("no stack trace found")
+	}
+	if remote.Type != "java.lang.NullPointerException" || remote.Message != "" {
+		t.Errorf("got type %q and message %q", remote.Type, remote.Message)
+	}
+	if len(remote.Frames) != 1 || remote.Frames[0].Line != 3 {
+		t.Errorf("frames = %v", remote.Frames)
+	}
+}
+
+const pythonChained = `Traceback (most recent call last):
+  File "/app/db.py", line 12, in connect
+    sock.connect(addr)
+ConnectionRefusedError: [Errno 111] Connection refused
+
+The above exception was the direct cause of the following exception:
+
+Traceback (most recent call last):
+  File "/app/main.py", line 30, in handle
+    order = load_order(order_id)
+            ^^^^^^^^^^^^^^^^^^^^
+  File "/app/orders.py", line 8, in load_order
+    return db.query(sql) + extra
+           ~~~~~~~~^^^^^
+orders.OrderError: could not load order 42
+while holding lock orders:42
+
+INFO request finished
+`
+
+func TestParsePythonTraceback(t *testing.T) {
+	remote, ok := ParsePythonTraceback(pythonChained)
+	if !ok {
+		t.Fatal("no traceback found")
+	}
+	if remote.Type != "orders.OrderError" {
+		t.Errorf("type = %q, want the exception raised last", remote.Type)
+	}
+	if want := "could not load order 42\nwhile holding lock orders:42"; remote.Message != want {
+		t.Errorf("message = %q, want %q", remote.Message, want)
+	}
+	want := []StackFrame{
+		{Language: "python", Module: "orders", Function: "load_order", File: "/app/orders.py", Line: 8},
+		{Language: "python", Module: "main", Function: "handle", File: "/app/main.py", Line: 30},
+	}
+	if !reflect.DeepEqual(remote.Frames, want) {
+		t.Errorf("frames = %+v, want %+v", remote.Frames, want)
+	}
+
+	remote, ok = ParsePythonTraceback("Traceback (most recent call last):\n  File \"x.py\", line 1, in <module>\nKeyboardInterrupt\nnext log line\n")
+	if !ok || remote.Type != "KeyboardInterrupt" || remote.Message != "" {
+		t.Errorf("exception without a message: %+v", remote)
+	}
+	if _, ok := ParsePythonTraceback("no traceback here"); ok {
+		t.Error("found a traceback in plain text")
+	}
+}
+
+func TestParseRemoteErrorJSON(t *testing.T) {
+	pythonJSON, err := json.Marshal(map[string]interface{}{
+		"status": 500,
+		"error": map[string]interface{}{
+			"detail":    "internal error",
+			"traceback": pythonChained,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, ok := ParseRemoteError("orders", string(pythonJSON))
+	if !ok || remote.Service != "orders" || remote.Language != "python" || remote.Type != "orders.OrderError" {
+		t.Errorf("nested object: %+v, %v", remote, ok)
+	}
+
+	javaJSON, err := json.Marshal([]interface{}{1, map[string]string{"trace": javaTrace}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, ok = ParseRemoteError("billing", string(javaJSON))
+	if !ok || remote.Language != "java" || remote.Type != "java.net.ConnectException" {
+		t.Errorf("array: %+v, %v", remote, ok)
+	}
+
+	// With traces under several keys, the first key in order wins every
+	// time.
+	both, err := json.Marshal(map[string]string{"b_java": javaTrace, "a_python": pythonChained})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if remote, ok := ParseRemoteError("svc", string(both)); !ok || remote.Language != "python" {
+			t.Fatalf("run %d: %+v, %v; want the trace under a_python", i, remote, ok)
+		}
+	}
+
+	for _, body := range []string{
+		`{"error": "not found"}`,
+		`{"traceback": `,
+		`[]`,
+		``,
+	} {
+		if remote, ok := ParseRemoteError("svc", body); ok {
+			t.Errorf("ParseRemoteError(%q) = %+v", body, remote)
+		}
+	}
+
+	if remote, ok := ParseRemoteError("svc", javaTrace); !ok || remote.Language != "java" {
+		t.Errorf("plain text body: %+v, %v", remote, ok)
+	}
+}
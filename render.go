@@ -0,0 +1,371 @@
+package trace_errors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// RendererEnv is the environment variable that selects the default
+// renderer by name. It is read when the default renderer is first used or
+// set, after every package has registered its renderers, and an unknown
+// name is reported on standard error.
+const RendererEnv = "TRACE_ERRORS_RENDERER"
+
+// maxChainLength bounds how many links the renderers follow.
+const maxChainLength = 10000
+
+// RenderOptions controls what a Renderer writes.
+type RenderOptions struct {
+	// Stack includes the frames of TraceError links.
+	Stack bool
+}
+
+// Renderer writes an error chain to w.
+type Renderer interface {
+	Render(w io.Writer, err error, opts RenderOptions) error
+}
+
+// RendererFunc adapts an ordinary function to the Renderer interface.
+type RendererFunc func(w io.Writer, err error, opts RenderOptions) error
+
+// Render calls f(w, err, opts).
+func (f RendererFunc) Render(w io.Writer, err error, opts RenderOptions) error {
+	return f(w, err, opts)
+}
+
+var (
+	renderersMu sync.RWMutex
+	renderers   = make(map[string]Renderer)
+
+	// defaultRenderer holds a rendererBox so that renderers of different
+	// concrete types can be stored.
+	defaultRenderer atomic.Value
+
+	envRendererOnce sync.Once
+)
+
+type rendererBox struct {
+	r Renderer
+}
+
+func init() {
+	RegisterRenderer("text", RendererFunc(renderText))
+	RegisterRenderer("json", RendererFunc(renderJSON))
+	RegisterRenderer("logfmt", RendererFunc(renderLogfmt))
+	RegisterRenderer("markdown", RendererFunc(renderMarkdown))
+
+	defaultRenderer.Store(rendererBox{RendererFunc(renderText)})
+}
+
+// loadEnvRenderer selects the renderer named by RendererEnv, once.
+func loadEnvRenderer() {
+	envRendererOnce.Do(func() {
+		name := os.Getenv(RendererEnv)
+		if name == "" {
+			return
+		}
+		r, ok := LookupRenderer(name)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "trace_errors: %s: unknown renderer %q\n", RendererEnv, name)
+			return
+		}
+		defaultRenderer.Store(rendererBox{r})
+	})
+}
+
+// RegisterRenderer makes a renderer available by name.
+// It panics if name is empty, r is nil or name is already registered.
+func RegisterRenderer(name string, r Renderer) {
+	if name == "" {
+		panic("trace_errors: RegisterRenderer with empty name")
+	}
+	if r == nil {
+		panic("trace_errors: RegisterRenderer renderer is nil")
+	}
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	if _, dup := renderers[name]; dup {
+		panic("trace_errors: RegisterRenderer called twice for " + name)
+	}
+	renderers[name] = r
+}
+
+// LookupRenderer returns the renderer registered under name.
+func LookupRenderer(name string) (Renderer, bool) {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	r, ok := renderers[name]
+	return r, ok
+}
+
+// Renderers returns the sorted names of the registered renderers.
+func Renderers() []string {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetDefaultRenderer selects the registered renderer used by
+// TraceError.Error and Render.
+func SetDefaultRenderer(name string) error {
+	r, ok := LookupRenderer(name)
+	if !ok {
+		return fmt.Errorf("trace_errors: unknown renderer %q", name)
+	}
+	loadEnvRenderer()
+	defaultRenderer.Store(rendererBox{r})
+	return nil
+}
+
+// DefaultRenderer returns the renderer used by TraceError.Error and Render.
+func DefaultRenderer() Renderer {
+	loadEnvRenderer()
+	return defaultRenderer.Load().(rendererBox).r
+}
+
+// Render writes err to w using the default renderer.
+func Render(w io.Writer, err error, opts RenderOptions) error {
+	return DefaultRenderer().Render(w, err, opts)
+}
+
+// renderText writes err the way TraceError.Error always has: the message
+// chain followed by the stack trace of every TraceError link, and then the
+// innermost runtime snapshot. Causes are written by this renderer too,
+// whichever renderer is the default.
+func renderText(w io.Writer, err error, opts RenderOptions) error {
+	if err == nil {
+		return nil
+	}
+	var b strings.Builder
+	if e, ok := err.(*TraceError); ok && e != nil {
+		writeText(&b, e, opts, true)
+	} else {
+		writeTextCause(&b, err, true)
+	}
+	_, werr := io.WriteString(w, b.String())
+	return werr
+}
+
+// writeText writes e and its causes. The runtime snapshot of the chain is
+// written only if snapshot is set, so that the links of a cause, whose
+// snapshots are the same chain's, leave it to the outermost link.
+func writeText(b *strings.Builder, e *TraceError, opts RenderOptions, snapshot bool) {
+	links := chain(e)
+	if truncated(links) {
+		// The cause leads back to e, so writing it would come back here;
+		// only the message chain is written.
+		b.WriteString(message(e))
+	} else {
+		if e.Msg != "" {
+			b.WriteString(e.Msg)
+			if e.Err != nil {
+				b.WriteString(": ")
+			}
+		}
+		if e.Err != nil {
+			writeTextCause(b, e.Err, false)
+		}
+	}
+
+	if opts.Stack && e.Frame != "" {
+		b.WriteString("\n")
+		b.WriteString(StackTrace(e))
+	}
+	if rs := innermostSnapshot(links); snapshot && opts.Stack && rs != nil {
+		b.WriteString("\nruntime: ")
+		b.WriteString(rs.String())
+	}
+}
+
+// writeTextCause writes err as its Error method would with the text
+// renderer as the default: a TraceError with its stack trace, as
+// includeStackInError asks, and a foreign wrapper as the text it puts in
+// front of its cause, followed by the cause. The runtime snapshot is
+// written only if snapshot is set, as for writeText.
+func writeTextCause(b *strings.Builder, err error, snapshot bool) {
+	links := chain(err)
+	for i, link := range links {
+		if e, ok := link.(*TraceError); ok {
+			writeText(b, e, RenderOptions{Stack: includeStackInError}, snapshot)
+			return
+		}
+		text, whole := foreignText(links, i)
+		b.WriteString(text)
+		if whole {
+			return
+		}
+	}
+}
+
+// message returns the message chain of err without any stack frames.
+func message(err error) string {
+	var b strings.Builder
+	links := chain(err)
+	for i, link := range links {
+		e, ok := link.(*TraceError)
+		if !ok {
+			text, whole := foreignText(links, i)
+			b.WriteString(text)
+			if whole {
+				break
+			}
+			continue
+		}
+		if e.Msg != "" {
+			b.WriteString(e.Msg)
+			if e.Err != nil && i+1 < len(links) {
+				b.WriteString(": ")
+			}
+		}
+	}
+	return b.String()
+}
+
+// foreignText returns the text that links[i], an error of another package,
+// adds to the message chain. A wrapper such as fmt.Errorf("...: %w") over a
+// TraceError would carry its rendered frames, so the text of the next link
+// is cut off and the caller goes on with that link. If it cannot be cut
+// off, it is replaced by its message and whole reports that the text
+// covers the rest of the chain.
+func foreignText(links []error, i int) (text string, whole bool) {
+	text = safeError(links[i])
+	if !hasTraceError(links[i+1:]) {
+		return text, true
+	}
+	next := safeError(links[i+1])
+	if strings.HasSuffix(text, next) {
+		return strings.TrimSuffix(text, next), false
+	}
+	return strings.Replace(text, next, message(links[i+1]), 1), true
+}
+
+func hasTraceError(links []error) bool {
+	for _, link := range links {
+		if _, ok := link.(*TraceError); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// chain returns err followed by every error reached through a single-error
+// Unwrap, outermost first. It stops at nil, at a cycle, or after
+// maxChainLength links. A nil *TraceError is replaced by a nilTraceError
+// placeholder, which ends the chain.
+func chain(err error) []error {
+	var links []error
+	var seen map[error]struct{}
+	for err != nil && len(links) < maxChainLength {
+		if e, ok := err.(*TraceError); ok && e == nil {
+			err = nilTraceError{}
+		}
+		if isPointer(err) {
+			if _, dup := seen[err]; dup {
+				break
+			}
+			if seen == nil {
+				seen = make(map[error]struct{})
+			}
+			seen[err] = struct{}{}
+		}
+		links = append(links, err)
+		err = unwrapOne(err)
+	}
+	return links
+}
+
+// enterChain returns the chain of err up to the first link already in
+// seen and adds the returned links to seen. The renderers that descend
+// into branches use it with leaveChain to stop at cycles through
+// multi-errors while still rendering a branch reached twice without one.
+func enterChain(err error, seen map[error]struct{}) []error {
+	links := chain(err)
+	for i, link := range links {
+		if !isPointer(link) {
+			continue
+		}
+		if _, dup := seen[link]; dup {
+			links = links[:i]
+			break
+		}
+		seen[link] = struct{}{}
+	}
+	return links
+}
+
+// visited reports whether err is in seen.
+func visited(seen map[error]struct{}, err error) bool {
+	if !isPointer(err) {
+		return false
+	}
+	_, ok := seen[err]
+	return ok
+}
+
+// leaveChain removes links added by enterChain from seen.
+func leaveChain(links []error, seen map[error]struct{}) {
+	for _, link := range links {
+		if isPointer(link) {
+			delete(seen, link)
+		}
+	}
+}
+
+// truncated reports whether chain stopped before the end of the chain
+// that links came from, at a cycle or at maxChainLength.
+func truncated(links []error) bool {
+	return len(links) > 0 && unwrapOne(links[len(links)-1]) != nil
+}
+
+func unwrapOne(err error) error {
+	if e, ok := err.(*TraceError); ok {
+		if e == nil {
+			return nil
+		}
+		return e.Err
+	}
+	return safeUnwrap(err)
+}
+
+// branches returns the errors wrapped by a multi-error, or nil.
+func branches(err error) []error {
+	return safeUnwrapMulti(err)
+}
+
+// isPointer reports whether err is a pointer and so can be tracked by
+// identity without risking a panic on an incomparable value.
+func isPointer(err error) bool {
+	return reflect.ValueOf(err).Kind() == reflect.Ptr
+}
+
+// typeName returns the dynamic type of err as printed by %T.
+func typeName(err error) string {
+	return fmt.Sprintf("%T", err)
+}
+
+// splitFrame splits a captured frame into its function, file and line.
+func splitFrame(frame string) (function, file string, line int) {
+	function, loc, ok := strings.Cut(frame, "\n\t")
+	if !ok {
+		return frame, "", 0
+	}
+	file = loc
+	if i := strings.LastIndexByte(loc, ':'); i >= 0 {
+		if n, err := strconv.Atoi(loc[i+1:]); err == nil {
+			file, line = loc[:i], n
+		}
+	}
+	return function, file, line
+}
@@ -0,0 +1,100 @@
+package trace_errors
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDOTLabel bounds the length of the message shown in a DOT node.
+const maxDOTLabel = 120
+
+func init() {
+	RegisterRenderer("dot", RendererFunc(renderDOT))
+}
+
+// renderDOT writes err as a Graphviz digraph: one node per link holding its
+// message, type and frame, and one edge per unwrap relation. Links reached
+// more than once, such as an error joined into several multi-errors, are
+// drawn once.
+func renderDOT(w io.Writer, err error, opts RenderOptions) error {
+	var b strings.Builder
+	b.WriteString("digraph errors {\n")
+	b.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	if err != nil {
+		g := &dotGraph{b: &b, opts: opts, ids: make(map[error]string)}
+		g.visit(err)
+	}
+	b.WriteString("}\n")
+	_, werr := io.WriteString(w, b.String())
+	return werr
+}
+
+type dotGraph struct {
+	b    *strings.Builder
+	opts RenderOptions
+	ids  map[error]string
+	n    int
+}
+
+// visit writes the node for err and everything below it, returning the
+// node's id.
+func (g *dotGraph) visit(err error) string {
+	if isPointer(err) {
+		if id, ok := g.ids[err]; ok {
+			return id
+		}
+	}
+	id := fmt.Sprintf("e%d", g.n)
+	g.n++
+	if isPointer(err) {
+		g.ids[err] = id
+	}
+	if g.n > maxChainLength {
+		fmt.Fprintf(g.b, "\t%s [label=\"...\"];\n", id)
+		return id
+	}
+
+	lines := []string{}
+	if e, ok := err.(*TraceError); ok && e != nil {
+		if e.Msg != "" {
+			lines = append(lines, dotTruncate(e.Msg))
+		}
+		lines = append(lines, typeName(err))
+		if g.opts.Stack && e.Frame != "" {
+			function, file, line := splitFrame(e.Frame)
+			lines = append(lines, function, fmt.Sprintf("%s:%d", file, line))
+		}
+	} else if bs := branches(err); len(bs) > 0 {
+		lines = append(lines, fmt.Sprintf("%d errors", len(bs)), typeName(err))
+	} else {
+		first, _, _ := strings.Cut(safeError(err), "\n")
+		lines = append(lines, dotTruncate(first), typeName(err))
+	}
+	fmt.Fprintf(g.b, "\t%s [label=%s];\n", id, dotQuote(strings.Join(lines, "\n")))
+
+	if next := unwrapOne(err); next != nil {
+		fmt.Fprintf(g.b, "\t%s -> %s;\n", id, g.visit(next))
+	}
+	for i, branch := range branches(err) {
+		if branch != nil {
+			child := g.visit(branch)
+			fmt.Fprintf(g.b, "\t%s -> %s [label=\"%d\", style=dashed];\n", id, child, i)
+		}
+	}
+	return id
+}
+
+func dotTruncate(s string) string {
+	if utf8.RuneCountInString(s) <= maxDOTLabel {
+		return s
+	}
+	return string([]rune(s)[:maxDOTLabel]) + "..."
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
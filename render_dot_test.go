@@ -0,0 +1,30 @@
+package trace_errors
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRenderDOTSharedLeaf(t *testing.T) {
+	shared := New("shared leaf")
+	err := errors.Join(
+		Wrap(shared, "first path"),
+		errors.Join(shared, New("other")),
+	)
+	var b strings.Builder
+	if rerr := renderDOT(&b, err, RenderOptions{}); rerr != nil {
+		t.Fatal(rerr)
+	}
+	out := b.String()
+
+	nodes := regexp.MustCompile(`(?m)^\t(e\d+) \[label="shared leaf\\n`).FindAllStringSubmatch(out, -1)
+	if len(nodes) != 1 {
+		t.Fatalf("got %d nodes for the shared leaf, want 1:\n%s", len(nodes), out)
+	}
+	edges := regexp.MustCompile(`(?m)^\te\d+ -> `+nodes[0][1]+`[ ;]`).FindAllString(out, -1)
+	if len(edges) != 2 {
+		t.Errorf("got %d edges into the shared leaf, want 2:\n%s", len(edges), out)
+	}
+}
@@ -0,0 +1,139 @@
+package trace_errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// jsonError is the JSON form of an error chain.
+type jsonError struct {
+	Message string     `json:"message"`
+	Chain   []jsonLink `json:"chain"`
+}
+
+// jsonLink is the JSON form of one link of an error chain.
+type jsonLink struct {
+	Msg      string                 `json:"msg,omitempty"`
+	Prefix   bool                   `json:"prefix,omitempty"` // Msg is followed by the text of the next link
+	Type     string                 `json:"type"`
+	Function string                 `json:"function,omitempty"`
+	File     string                 `json:"file,omitempty"`
+	Line     int                    `json:"line,omitempty"`
+	Site     string                 `json:"site,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+	Stack    []StackFrame           `json:"stack,omitempty"`
+	Runtime  *RuntimeSnapshot       `json:"runtime,omitempty"`
+	Enqueued *Provenance            `json:"enqueued,omitempty"`
+	Remote   *RemoteError           `json:"remote,omitempty"`
+	Errors   []jsonError            `json:"errors,omitempty"`
+}
+
+func renderJSON(w io.Writer, err error, opts RenderOptions) error {
+	if err == nil {
+		_, werr := io.WriteString(w, "null")
+		return werr
+	}
+	return json.NewEncoder(w).Encode(toJSON(err, opts))
+}
+
+func toJSON(err error, opts RenderOptions) jsonError {
+	return toJSONSeen(err, opts, make(map[error]struct{}))
+}
+
+// toJSONSeen converts the chain of err, leaving out branches that lead back
+// to a link in seen.
+func toJSONSeen(err error, opts RenderOptions, seen map[error]struct{}) jsonError {
+	je := jsonError{Message: message(err)}
+	links := enterChain(err, seen)
+	defer leaveChain(links, seen)
+	// Only the innermost full stack is written; the stacks of the outer
+	// links are mostly the same frames.
+	stackAt := -1
+	if opts.Stack {
+		for i := len(links) - 1; i >= 0; i-- {
+			if e, ok := links[i].(*TraceError); ok && e.stack != nil {
+				stackAt = i
+				break
+			}
+		}
+	}
+	for i, link := range links {
+		jl := jsonLink{Type: typeName(link)}
+		if e, ok := link.(*TraceError); ok {
+			jl.Msg = e.Msg
+			jl.Fields = jsonFields(e.Fields)
+			jl.Runtime = e.Runtime
+			if opts.Stack && e.Enqueued != nil {
+				jl.Enqueued = &Provenance{Frame: e.Enqueued.Frame, Fields: jsonFields(e.Enqueued.Fields)}
+			}
+			if opts.Stack && e.Frame != "" {
+				jl.Function, jl.File, jl.Line = splitFrame(e.Frame)
+			}
+			if e.Frame != "" {
+				jl.Site = e.siteCode()
+			}
+			if i == stackAt {
+				jl.Stack = e.Stack()
+			}
+		} else {
+			var whole bool
+			jl.Msg, whole = foreignText(links, i)
+			jl.Prefix = !whole
+			jl.Remote, _ = link.(*RemoteError)
+		}
+		for _, branch := range branches(link) {
+			if branch == nil {
+				continue
+			}
+			if visited(seen, branch) {
+				continue
+			}
+			jl.Errors = append(jl.Errors, toJSONSeen(branch, opts, seen))
+		}
+		je.Chain = append(je.Chain, jl)
+	}
+	return je
+}
+
+// jsonFields returns fields with every value JSON cannot encode, such as a
+// func, a channel or NaN, replaced by its fmt.Sprint text, so that one odd
+// field does not make the whole chain fail to encode.
+func jsonFields(fields map[string]interface{}) map[string]interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		out[k] = jsonValue(v)
+	}
+	return out
+}
+
+func jsonValue(v interface{}) (out interface{}) {
+	switch n := v.(type) {
+	case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return v
+	case float32:
+		if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+			return fmt.Sprint(n)
+		}
+		return v
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return fmt.Sprint(n)
+		}
+		return v
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			out = fmt.Sprint(v)
+		}
+	}()
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return json.RawMessage(data)
+}
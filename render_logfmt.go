@@ -0,0 +1,95 @@
+package trace_errors
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// renderLogfmt writes err as a single logfmt line: the message chain, the
+// type of the innermost error, the innermost frame, the runtime snapshot
+// and the chain's fields.
+func renderLogfmt(w io.Writer, err error, opts RenderOptions) error {
+	if err == nil {
+		return nil
+	}
+	links := chain(err)
+	var b strings.Builder
+	writeLogfmt(&b, "msg", message(err))
+	writeLogfmt(&b, "type", typeName(links[len(links)-1]))
+	if opts.Stack {
+		if frame := innermostFrame(links); frame != "" {
+			function, file, line := splitFrame(frame)
+			writeLogfmt(&b, "func", function)
+			writeLogfmt(&b, "file", file)
+			writeLogfmt(&b, "line", strconv.Itoa(line))
+		}
+	}
+	if rs := innermostSnapshot(links); rs != nil {
+		writeLogfmt(&b, "runtime.goroutines", strconv.Itoa(rs.Goroutines))
+		writeLogfmt(&b, "runtime.heap_in_use", strconv.FormatUint(rs.HeapInUse, 10))
+		writeLogfmt(&b, "runtime.num_gc", strconv.FormatUint(uint64(rs.NumGC), 10))
+		writeLogfmt(&b, "runtime.gc_pause_total", rs.PauseTotal.String())
+		writeLogfmt(&b, "runtime.open_files", strconv.Itoa(rs.OpenFiles))
+		writeLogfmt(&b, "runtime.gomaxprocs", strconv.Itoa(rs.GOMAXPROCS))
+	}
+	fields := FieldsOf(err)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeLogfmt(&b, k, fmt.Sprint(fields[k]))
+	}
+	b.WriteByte('\n')
+	_, werr := io.WriteString(w, b.String())
+	return werr
+}
+
+// innermostFrame returns the frame of the last TraceError among links.
+func innermostFrame(links []error) string {
+	for i := len(links) - 1; i >= 0; i-- {
+		if e, ok := links[i].(*TraceError); ok && e.Frame != "" {
+			return e.Frame
+		}
+	}
+	return ""
+}
+
+func writeLogfmt(b *strings.Builder, key, value string) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(logfmtKey(key))
+	b.WriteByte('=')
+	if value == "" || strings.ContainsAny(value, " =\"\\") || !isPrintable(value) {
+		b.WriteString(strconv.Quote(value))
+	} else {
+		b.WriteString(value)
+	}
+}
+
+// logfmtKey replaces the characters that cannot appear in a logfmt key.
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if !strconv.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package trace_errors
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// renderMarkdown writes err as a Markdown fragment suitable for issue
+// trackers and chat: the message chain, the fields and a numbered list of
+// frames. The branches of multi-errors are rendered as nested lists.
+func renderMarkdown(w io.Writer, err error, opts RenderOptions) error {
+	if err == nil {
+		return nil
+	}
+	var b strings.Builder
+	writeMarkdown(&b, err, opts, "", make(map[error]struct{}))
+	_, werr := io.WriteString(w, b.String())
+	return werr
+}
+
+// writeMarkdown writes err at the given indent. A branch that leads back
+// to a link in seen is written as a cycle marker.
+func writeMarkdown(b *strings.Builder, err error, opts RenderOptions, indent string, seen map[error]struct{}) {
+	links := enterChain(err, seen)
+	defer leaveChain(links, seen)
+	fmt.Fprintf(b, "%s**%s**\n", indent, markdownEscape(message(err)))
+
+	fields := FieldsOf(err)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s- `%s`: %s\n", indent, markdownCode(k), markdownEscape(fmt.Sprint(fields[k])))
+	}
+
+	if rs := innermostSnapshot(links); rs != nil {
+		fmt.Fprintf(b, "%s- runtime: `%s`\n", indent, markdownCode(rs.String()))
+	}
+
+	if opts.Stack {
+		n := 0
+		for i := len(links) - 1; i >= 0; i-- {
+			e, ok := links[i].(*TraceError)
+			if !ok || e.Frame == "" {
+				continue
+			}
+			n++
+			function, file, line := splitFrame(e.Frame)
+			fmt.Fprintf(b, "%s%d. `%s` at `%s:%d`\n", indent, n, markdownCode(function), markdownCode(file), line)
+		}
+	}
+
+	for _, link := range links {
+		for _, branch := range branches(link) {
+			if branch == nil {
+				continue
+			}
+			if visited(seen, branch) {
+				fmt.Fprintf(b, "%s- _(cycle)_\n", indent)
+				continue
+			}
+			fmt.Fprintf(b, "%s-\n", indent)
+			writeMarkdown(b, branch, opts, indent+"  ", seen)
+		}
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "*", "\\*", "_", "\\_", "`", "\\`", "[", "\\[", "]", "\\]",
+	"<", "&lt;", ">", "&gt;", "|", "\\|", "\n", " ", "\r", " ",
+)
+
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// markdownCode makes s safe to place inside a single-backtick code span.
+func markdownCode(s string) string {
+	return strings.NewReplacer("`", "'", "\n", " ", "\r", " ").Replace(s)
+}
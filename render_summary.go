@@ -0,0 +1,162 @@
+package trace_errors
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterRenderer("summary", SummaryRenderer{})
+}
+
+// SummaryRenderer renders large multi-errors, such as the result of a batch
+// job joining one error per failed item, as a summary: the branches are
+// grouped by code, or by fingerprint when they have none, and each group
+// shows its size, the items it affected and one representative trace.
+//
+// The zero value uses the defaults documented on the fields.
+type SummaryRenderer struct {
+	// MaxGroups is the number of groups shown, largest first. Zero means 10.
+	MaxGroups int
+	// MaxItems is the number of item identifiers listed per group. Zero
+	// means 20.
+	MaxItems int
+	// ItemFields are the fields that identify the item of a branch, tried
+	// in order. Nil means "item", "item_id" and "id".
+	ItemFields []string
+}
+
+type summaryGroup struct {
+	key   string
+	count int
+	first error
+	items []string
+}
+
+// Render implements Renderer.
+func (r SummaryRenderer) Render(w io.Writer, err error, opts RenderOptions) error {
+	if err == nil {
+		return nil
+	}
+	maxGroups, maxItems, itemFields := r.MaxGroups, r.MaxItems, r.ItemFields
+	if maxGroups <= 0 {
+		maxGroups = 10
+	}
+	if maxItems <= 0 {
+		maxItems = 20
+	}
+	if itemFields == nil {
+		itemFields = []string{"item", "item_id", "id"}
+	}
+
+	// The links in front of the first multi-error make up the header.
+	var header []string
+	var leaves []error
+	for _, link := range chain(err) {
+		if bs := branches(link); bs != nil {
+			seen := make(map[error]struct{})
+			if isPointer(link) {
+				seen[link] = struct{}{}
+			}
+			leaves = flattenBranches(bs, 0, seen)
+			break
+		}
+		if e, ok := link.(*TraceError); ok {
+			if e.Msg != "" {
+				header = append(header, e.Msg)
+			}
+			continue
+		}
+		break
+	}
+	if leaves == nil {
+		leaves = []error{err}
+		header = nil
+	}
+
+	groups := make(map[string]*summaryGroup)
+	var order []*summaryGroup
+	for _, leaf := range leaves {
+		key := "fingerprint " + Fingerprint(leaf)
+		if code := Code(leaf); code != "" {
+			key = "code " + code
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &summaryGroup{key: key, first: leaf}
+			groups[key] = g
+			order = append(order, g)
+		}
+		g.count++
+		fields := FieldsOf(leaf)
+		for _, f := range itemFields {
+			if v, ok := fields[f]; ok {
+				g.items = append(g.items, fmt.Sprint(v))
+				break
+			}
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].count > order[j].count })
+
+	var b strings.Builder
+	if len(header) > 0 {
+		b.WriteString(strings.Join(header, ": "))
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "%d errors in %d groups\n", len(leaves), len(order))
+	for i, g := range order {
+		if i == maxGroups {
+			rest := 0
+			for _, g := range order[i:] {
+				rest += g.count
+			}
+			fmt.Fprintf(&b, "... %d more groups (%d errors) not shown\n", len(order)-i, rest)
+			break
+		}
+		fmt.Fprintf(&b, "[%d] %dx %s\n", i+1, g.count, g.key)
+		if len(g.items) > 0 {
+			items := g.items
+			more := ""
+			if len(items) > maxItems {
+				more = fmt.Sprintf(" (+%d more)", len(items)-maxItems)
+				items = items[:maxItems]
+			}
+			fmt.Fprintf(&b, "    items: %s%s\n", strings.Join(items, ", "), more)
+		}
+		b.WriteString(indent(message(g.first), "    "))
+		if opts.Stack {
+			if trace := StackTrace(g.first); trace != "" {
+				b.WriteString(indent(trace, "        "))
+			}
+		}
+	}
+	_, werr := io.WriteString(w, b.String())
+	return werr
+}
+
+// flattenBranches returns the branches of a multi-error, replacing
+// branches that are themselves bare multi-errors by their own branches.
+// seen holds the multi-errors being flattened; a branch leading back to
+// one of them is kept as a leaf.
+func flattenBranches(bs []error, depth int, seen map[error]struct{}) []error {
+	var leaves []error
+	for _, b := range bs {
+		if b == nil {
+			continue
+		}
+		if _, ok := b.(*TraceError); !ok && depth < maxChainLength && !visited(seen, b) {
+			if nested := branches(b); nested != nil {
+				if isPointer(b) {
+					seen[b] = struct{}{}
+				}
+				leaves = append(leaves, flattenBranches(nested, depth+1, seen)...)
+				delete(seen, b)
+				continue
+			}
+		}
+		leaves = append(leaves, b)
+	}
+	return leaves
+}
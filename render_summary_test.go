@@ -0,0 +1,127 @@
+package trace_errors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// itemFailure returns an error for item with the given code, created at a
+// single site so that errors without a code share a fingerprint.
+func itemFailure(item, code, msg string) error {
+	err := WithFields(New(msg), Field{Key: ItemField, Value: item})
+	if code != "" {
+		err = WithCode(err, code)
+	}
+	return err
+}
+
+func renderSummary(t *testing.T, r SummaryRenderer, err error, opts RenderOptions) string {
+	t.Helper()
+	var b strings.Builder
+	if rerr := r.Render(&b, err, opts); rerr != nil {
+		t.Fatal(rerr)
+	}
+	return b.String()
+}
+
+func TestSummaryRendererGroups(t *testing.T) {
+	timeout1 := itemFailure("t1", "", "timeout")
+	timeout2 := itemFailure("t2", "", "timeout")
+	err := Wrap(Wrap(errors.Join(
+		itemFailure("a", "E_LOCK", "row locked"),
+		timeout1,
+		errors.Join(
+			itemFailure("b", "E_LOCK", "row locked again"),
+			itemFailure("c", "E_LOCK", "row locked"),
+		),
+		WithCode(errors.New("disk full"), "E_DISK"),
+		timeout2,
+	), "importing"), "nightly job")
+
+	want := "nightly job: importing: 6 errors in 3 groups\n" +
+		"[1] 3x code E_LOCK\n" +
+		"    items: a, b, c\n" +
+		"    row locked\n" +
+		"[2] 2x fingerprint " + Fingerprint(timeout1) + "\n" +
+		"    items: t1, t2\n" +
+		"    timeout\n" +
+		"[3] 1x code E_DISK\n" +
+		"    disk full\n"
+	if got := renderSummary(t, SummaryRenderer{}, err, RenderOptions{}); got != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", got, want)
+	}
+	if Fingerprint(timeout1) != Fingerprint(timeout2) {
+		t.Fatal("the timeouts do not share a fingerprint")
+	}
+}
+
+func TestSummaryRendererCaps(t *testing.T) {
+	var bs []error
+	for i := 0; i < 5; i++ {
+		bs = append(bs, itemFailure(fmt.Sprint("a", i), "E_A", "a"))
+	}
+	for i := 0; i < 3; i++ {
+		bs = append(bs, itemFailure(fmt.Sprint("b", i), "E_B", "b"))
+	}
+	bs = append(bs, itemFailure("c0", "E_C", "c"))
+	err := errors.Join(bs...)
+
+	got := renderSummary(t, SummaryRenderer{MaxGroups: 1, MaxItems: 2}, err, RenderOptions{})
+	want := "9 errors in 3 groups\n" +
+		"[1] 5x code E_A\n" +
+		"    items: a0, a1 (+3 more)\n" +
+		"    a\n" +
+		"... 2 more groups (4 errors) not shown\n"
+	if got != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The defaults show every group here.
+	if got := renderSummary(t, SummaryRenderer{}, err, RenderOptions{}); strings.Contains(got, "not shown") || strings.Contains(got, "more)") {
+		t.Errorf("defaults capped the summary:\n%s", got)
+	}
+}
+
+func TestSummaryRendererItemFields(t *testing.T) {
+	err := errors.Join(
+		WithFields(New("x"), Field{Key: "sku", Value: 7}, Field{Key: "id", Value: "ignored"}),
+		WithFields(New("x"), Field{Key: "id", Value: "only id"}),
+	)
+	got := renderSummary(t, SummaryRenderer{ItemFields: []string{"sku"}}, err, RenderOptions{})
+	if !strings.Contains(got, "    items: 7\n") {
+		t.Errorf("custom item fields:\n%s", got)
+	}
+	got = renderSummary(t, SummaryRenderer{}, err, RenderOptions{})
+	if !strings.Contains(got, "    items: ignored, only id\n") {
+		t.Errorf("default item fields:\n%s", got)
+	}
+}
+
+func TestSummaryRendererRepresentativeStack(t *testing.T) {
+	first := itemFailure("a", "E_LOCK", "first")
+	second := Wrap(itemFailure("b", "E_LOCK", "second"), "retrying")
+	got := renderSummary(t, SummaryRenderer{}, errors.Join(first, second), RenderOptions{Stack: true})
+	if !strings.Contains(got, "    first\n") || strings.Contains(got, "second") {
+		t.Errorf("the group is not represented by its first error alone:\n%s", got)
+	}
+	if !strings.Contains(got, "        ") || !strings.Contains(got, "itemFailure") {
+		t.Errorf("no stack for the representative:\n%s", got)
+	}
+}
+
+func TestSummaryRendererSingleError(t *testing.T) {
+	err := Wrap(itemFailure("a", "E_LOCK", "row locked"), "saving")
+	got := renderSummary(t, SummaryRenderer{}, err, RenderOptions{})
+	want := "1 errors in 1 groups\n" +
+		"[1] 1x code E_LOCK\n" +
+		"    items: a\n" +
+		"    saving: row locked\n"
+	if got != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", got, want)
+	}
+	if got := renderSummary(t, SummaryRenderer{}, nil, RenderOptions{}); got != "" {
+		t.Errorf("nil error rendered %q", got)
+	}
+}
@@ -0,0 +1,249 @@
+package trace_errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMessageForeignWrapper(t *testing.T) {
+	inner := New("inner")
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"prefix", fmt.Errorf("context: %w", inner), "context: inner"},
+		{"prefix over wrap", Wrap(fmt.Errorf("context: %w", Wrap(inner, "middle")), "outer"), "outer: context: middle: inner"},
+		{"suffix", fmt.Errorf("%w (context)", inner), "inner (context)"},
+		{"foreign leaf", Wrap(errors.New("leaf"), "outer"), "outer: leaf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := message(tt.err); got != tt.want {
+				t.Errorf("message() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderersOmitWrappedFrames(t *testing.T) {
+	err := Wrap(fmt.Errorf("context: %w", New("inner")), "outer")
+	for _, name := range []string{"json", "logfmt", "markdown"} {
+		r, _ := LookupRenderer(name)
+		var b bytes.Buffer
+		if rerr := r.Render(&b, err, RenderOptions{}); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if strings.Contains(b.String(), "render_test.go") {
+			t.Errorf("%s output contains a frame of the wrapped error:\n%s", name, b.String())
+		}
+	}
+}
+
+func TestRenderersCyclicBranches(t *testing.T) {
+	j := &cyclicJoin{}
+	j.errs = []error{New("leaf"), Wrap(j, "back"), j}
+	for _, name := range Renderers() {
+		r, _ := LookupRenderer(name)
+		var b bytes.Buffer
+		if err := r.Render(&b, j, RenderOptions{Stack: true}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if name == "text" || name == "logfmt" {
+			continue // these do not descend into branches
+		}
+		if !strings.Contains(b.String(), "leaf") {
+			t.Errorf("%s output does not contain the leaf:\n%s", name, b.String())
+		}
+	}
+}
+
+func TestJSONSharedBranch(t *testing.T) {
+	shared := New("shared")
+	var je jsonError
+	b, _ := json.Marshal(toJSON(errors.Join(shared, shared), RenderOptions{}))
+	if err := json.Unmarshal(b, &je); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(je.Chain[0].Errors); n != 2 {
+		t.Errorf("got %d branches, want 2", n)
+	}
+}
+
+func TestNilTraceErrorLink(t *testing.T) {
+	var nilTE *TraceError
+	err := WithCode(Wrap(nilTE, "outer"), "x")
+	if got := message(err); got != "outer: <nil>" {
+		t.Errorf("message() = %q", got)
+	}
+	if got := StackTrace(err); strings.Contains(got, "<nil>") || got == "" {
+		t.Errorf("StackTrace() = %q", got)
+	}
+	if FieldsOf(err)[CodeField] != "x" {
+		t.Errorf("FieldsOf() = %v", FieldsOf(err))
+	}
+	if je := toJSON(err, RenderOptions{Stack: true}); len(je.Chain) != 3 {
+		t.Errorf("toJSON() has %d links, want 3", len(je.Chain))
+	}
+	if errors.Is(err, errors.New("other")) {
+		t.Error("errors.Is matched")
+	}
+	if got := nilTE.Error(); got != "<nil>" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestRenderTextRuntimeOnce(t *testing.T) {
+	SetRuntimeSnapshots(true, 0)
+	defer SetRuntimeSnapshots(false, 0)
+	err := Wrap(fmt.Errorf("context: %w", Wrap(New("leaf"), "middle")), "outer")
+	rs := innermostSnapshot(chain(err))
+	if rs == nil {
+		t.Fatal("no snapshot taken")
+	}
+
+	var b strings.Builder
+	if rerr := renderText(&b, err, RenderOptions{Stack: true}); rerr != nil {
+		t.Fatal(rerr)
+	}
+	out := b.String()
+	if n := strings.Count(out, "runtime: "); n != 1 {
+		t.Errorf("got %d runtime lines, want 1:\n%s", n, out)
+	}
+	if !strings.HasSuffix(out, "\nruntime: "+rs.String()) {
+		t.Errorf("output does not end with the innermost snapshot:\n%s", out)
+	}
+
+	b.Reset()
+	if rerr := renderText(&b, fmt.Errorf("top: %w", err), RenderOptions{Stack: true}); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if n := strings.Count(b.String(), "runtime: "); n != 1 {
+		t.Errorf("foreign top: got %d runtime lines, want 1:\n%s", n, b.String())
+	}
+}
+
+func TestJSONUnencodableFields(t *testing.T) {
+	err := WithFields(New("x"),
+		Field{Key: "cb", Value: func() {}},
+		Field{Key: "ch", Value: make(chan int)},
+		Field{Key: "nan", Value: math.NaN()},
+		Field{Key: "ok", Value: []int{1, 2}},
+	)
+	data, merr := MarshalChain(err)
+	if merr != nil {
+		t.Fatalf("MarshalChain: %v", merr)
+	}
+	var je jsonError
+	if uerr := json.Unmarshal(data, &je); uerr != nil {
+		t.Fatal(uerr)
+	}
+	fields := je.Chain[0].Fields
+	if s, _ := fields["cb"].(string); !strings.HasPrefix(s, "0x") {
+		t.Errorf("cb = %#v, want its fmt.Sprint text", fields["cb"])
+	}
+	if fields["nan"] != "NaN" {
+		t.Errorf("nan = %#v, want \"NaN\"", fields["nan"])
+	}
+	if fmt.Sprint(fields["ok"]) != "[1 2]" {
+		t.Errorf("ok = %#v, want the list kept", fields["ok"])
+	}
+
+	if serr := SetDefaultRenderer("json"); serr != nil {
+		t.Fatal(serr)
+	}
+	defer SetDefaultRenderer("text")
+	if err.Error() == "" {
+		t.Error("Error() is empty under the json renderer")
+	}
+}
+
+func TestErrorNeverEmpty(t *testing.T) {
+	if got := (&TraceError{}).Error(); got == "" {
+		t.Error("Error() of an empty TraceError is empty")
+	}
+}
+
+func TestRenderTextCausesUnderOtherDefault(t *testing.T) {
+	inner := New("inner")
+	err := Wrap(fmt.Errorf("middle: %w", Wrap(inner, "wrap")), "outer")
+	var want bytes.Buffer
+	if rerr := renderText(&want, err, RenderOptions{Stack: true}); rerr != nil {
+		t.Fatal(rerr)
+	}
+
+	for _, name := range []string{"json", "logfmt"} {
+		if serr := SetDefaultRenderer(name); serr != nil {
+			t.Fatal(serr)
+		}
+		var got bytes.Buffer
+		if rerr := renderText(&got, err, RenderOptions{Stack: true}); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s default: text rendering\n%s\nwant\n%s", name, got.String(), want.String())
+		}
+		if msg := err.Error(); strings.HasSuffix(msg, "\n") {
+			t.Errorf("%s default: Error() ends with a newline: %q", name, msg)
+		}
+	}
+	_ = SetDefaultRenderer("text")
+
+	if strings.Contains(want.String(), `"message"`) {
+		t.Errorf("text rendering contains JSON:\n%s", want.String())
+	}
+}
+
+func TestRendererEnv(t *testing.T) {
+	defer func() {
+		envRendererOnce = sync.Once{}
+		_ = SetDefaultRenderer("text")
+	}()
+
+	// dot and summary register in init functions of files after render.go.
+	for _, name := range []string{"dot", "summary"} {
+		t.Setenv(RendererEnv, name)
+		envRendererOnce = sync.Once{}
+		defaultRenderer.Store(rendererBox{RendererFunc(renderText)})
+		want, _ := LookupRenderer(name)
+		if got := DefaultRenderer(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s=%s: default renderer is %v", RendererEnv, name, got)
+		}
+	}
+
+	t.Setenv(RendererEnv, "nosuch")
+	envRendererOnce = sync.Once{}
+	defaultRenderer.Store(rendererBox{RendererFunc(renderText)})
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	DefaultRenderer()
+	os.Stderr = stderr
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if !strings.Contains(string(out), `unknown renderer "nosuch"`) {
+		t.Errorf("unknown name reported as %q", out)
+	}
+
+	// A name set in code wins over the environment.
+	t.Setenv(RendererEnv, "json")
+	envRendererOnce = sync.Once{}
+	if err := SetDefaultRenderer("logfmt"); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := LookupRenderer("logfmt")
+	if got := DefaultRenderer(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("SetDefaultRenderer overridden by %s", RendererEnv)
+	}
+}
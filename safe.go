@@ -0,0 +1,62 @@
+package trace_errors
+
+import "fmt"
+
+// safeError returns err.Error(). If the method panics, as a method with a
+// nil pointer receiver may, it returns a placeholder naming the type of
+// err and the panic value instead.
+func safeError(err error) (s string) {
+	defer func() {
+		if r := recover(); r != nil {
+			s = fmt.Sprintf("<%T.Error panicked: %v>", err, r)
+		}
+	}()
+	return err.Error()
+}
+
+// unwrapPanicError stands in for the cause of an error whose Unwrap method
+// panicked.
+type unwrapPanicError struct {
+	typ   string
+	value interface{}
+}
+
+func (e *unwrapPanicError) Error() string {
+	return fmt.Sprintf("<%s.Unwrap panicked: %v>", e.typ, e.value)
+}
+
+// nilTraceError stands in for a nil *TraceError found in a chain, so that
+// code walking the chain never dereferences it. It ends the chain.
+type nilTraceError struct{}
+
+func (nilTraceError) Error() string { return "<nil>" }
+
+// safeUnwrap returns the result of the Unwrap() error method of err, or
+// nil if it has none. If the method panics, it returns an
+// unwrapPanicError in place of the cause.
+func safeUnwrap(err error) (cause error) {
+	defer func() {
+		if r := recover(); r != nil {
+			cause = &unwrapPanicError{typ: typeName(err), value: r}
+		}
+	}()
+	if u, ok := err.(interface{ Unwrap() error }); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
+
+// safeUnwrapMulti returns the result of the Unwrap() []error method of
+// err, or nil if it has none. If the method panics, it returns a single
+// unwrapPanicError in place of the branches.
+func safeUnwrapMulti(err error) (branches []error) {
+	defer func() {
+		if r := recover(); r != nil {
+			branches = []error{&unwrapPanicError{typ: typeName(err), value: r}}
+		}
+	}()
+	if u, ok := err.(interface{ Unwrap() []error }); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
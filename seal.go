@@ -0,0 +1,146 @@
+package trace_errors
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// sealPrefix starts every sealed envelope, naming the format version.
+const sealPrefix = "te1."
+
+// sealHeaderSize is the size of the version byte and the key id.
+const sealHeaderSize = 1 + 4
+
+// ErrUnknownKey is returned by Open for envelopes sealed with a key the
+// Keyring does not hold.
+var ErrUnknownKey = errors.New("trace_errors: envelope sealed with unknown key")
+
+// Keyring seals error chains into opaque envelopes with AES-GCM and opens
+// them again. Envelopes name the key that sealed them, so keys can be
+// rotated: add the new key, make it primary, and remove the old one once
+// no envelope sealed with it needs opening.
+//
+// A Keyring is safe for concurrent use.
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       map[uint32]cipher.AEAD
+	primary    uint32
+	hasPrimary bool
+}
+
+// NewKeyring returns an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[uint32]cipher.AEAD)}
+}
+
+// Add adds an AES-128, AES-192 or AES-256 key under id. The first key
+// added becomes the primary key.
+func (k *Keyring) Add(id uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = aead
+	if !k.hasPrimary {
+		k.primary, k.hasPrimary = id, true
+	}
+	return nil
+}
+
+// SetPrimary makes the key with id the one used by Seal.
+func (k *Keyring) SetPrimary(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("trace_errors: no key with id %d", id)
+	}
+	k.primary, k.hasPrimary = id, true
+	return nil
+}
+
+// Remove removes the key with id. Removing the primary key leaves the
+// Keyring unable to seal until SetPrimary is called.
+func (k *Keyring) Remove(id uint32) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, id)
+	if k.primary == id {
+		k.hasPrimary = false
+	}
+}
+
+// Seal serializes the chain of err with MarshalChain and encrypts it with
+// the primary key. The envelope is printable and safe to put in URLs and
+// HTTP headers.
+func (k *Keyring) Seal(err error) (string, error) {
+	data, merr := MarshalChain(err)
+	if merr != nil {
+		return "", merr
+	}
+
+	k.mu.RLock()
+	aead, ok := k.keys[k.primary]
+	id := k.primary
+	ok = ok && k.hasPrimary
+	k.mu.RUnlock()
+	if !ok {
+		return "", errors.New("trace_errors: keyring has no primary key")
+	}
+
+	buf := make([]byte, sealHeaderSize+aead.NonceSize(), sealHeaderSize+aead.NonceSize()+len(data)+aead.Overhead())
+	buf[0] = 1
+	binary.BigEndian.PutUint32(buf[1:sealHeaderSize], id)
+	nonce := buf[sealHeaderSize:]
+	if _, rerr := rand.Read(nonce); rerr != nil {
+		return "", rerr
+	}
+	buf = aead.Seal(buf, nonce, data, buf[:sealHeaderSize])
+	return sealPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Open authenticates and decrypts an envelope produced by Seal and
+// rebuilds the error chain with UnmarshalChain. Whitespace in blob, as
+// left by copying it from a terminal or an email, is ignored.
+func (k *Keyring) Open(blob string) (cause error, err error) {
+	blob = strings.Join(strings.Fields(blob), "")
+	if !strings.HasPrefix(blob, sealPrefix) {
+		return nil, errors.New("trace_errors: not a sealed envelope")
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(blob[len(sealPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("trace_errors: malformed envelope: %w", err)
+	}
+	if len(buf) < sealHeaderSize || buf[0] != 1 {
+		return nil, errors.New("trace_errors: malformed envelope")
+	}
+	id := binary.BigEndian.Uint32(buf[1:sealHeaderSize])
+
+	k.mu.RLock()
+	aead, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w (id %d)", ErrUnknownKey, id)
+	}
+	if len(buf) < sealHeaderSize+aead.NonceSize() {
+		return nil, errors.New("trace_errors: malformed envelope")
+	}
+	nonce := buf[sealHeaderSize : sealHeaderSize+aead.NonceSize()]
+	data, err := aead.Open(nil, nonce, buf[sealHeaderSize+aead.NonceSize():], buf[:sealHeaderSize])
+	if err != nil {
+		return nil, errors.New("trace_errors: envelope failed authentication")
+	}
+	return UnmarshalChain(data)
+}
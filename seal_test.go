@@ -0,0 +1,153 @@
+package trace_errors
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	ring := NewKeyring()
+	if err := ring.Add(1, testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	err := WithCode(Wrap(New("disk full"), "saving upload"), "E_DISK")
+	blob, serr := ring.Seal(err)
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	if !strings.HasPrefix(blob, sealPrefix) {
+		t.Errorf("envelope %q does not start with %q", blob, sealPrefix)
+	}
+	if strings.Contains(blob, "disk full") {
+		t.Error("envelope contains the plain message")
+	}
+
+	// Whitespace from copying the envelope out of a terminal is ignored.
+	wrapped := blob[:10] + "\n  " + blob[10:] + "\n"
+	got, oerr := ring.Open(wrapped)
+	if oerr != nil {
+		t.Fatal(oerr)
+	}
+	if got.Error() != err.Error() {
+		t.Errorf("opened %q, want %q", got.Error(), err.Error())
+	}
+	if Code(got) != "E_DISK" {
+		t.Errorf("code = %q, want E_DISK", Code(got))
+	}
+}
+
+func TestKeyringWrongKey(t *testing.T) {
+	sealer, opener := NewKeyring(), NewKeyring()
+	if err := sealer.Add(1, testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := opener.Add(1, testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := sealer.Seal(New("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cause, err := opener.Open(blob); err == nil || cause != nil {
+		t.Errorf("Open with the wrong key = %v, %v; want an error", cause, err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	ring := NewKeyring()
+	if err := ring.Add(1, testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	old, err := ring.Seal(New("sealed with key 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ring.Add(2, testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.SetPrimary(2); err != nil {
+		t.Fatal(err)
+	}
+	current, err := ring.Seal(New("sealed with key 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blob := range []string{old, current} {
+		if _, err := ring.Open(blob); err != nil {
+			t.Errorf("Open after adding key 2: %v", err)
+		}
+	}
+
+	ring.Remove(1)
+	if _, err := ring.Open(old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open of an envelope of a removed key = %v, want ErrUnknownKey", err)
+	}
+	if _, err := ring.Open(current); err != nil {
+		t.Errorf("Open after removing key 1: %v", err)
+	}
+
+	ring.Remove(2)
+	if _, err := ring.Seal(New("x")); err == nil {
+		t.Error("Seal without a primary key succeeded")
+	}
+	if err := ring.SetPrimary(3); err == nil {
+		t.Error("SetPrimary of a missing key succeeded")
+	}
+}
+
+func TestKeyringTampered(t *testing.T) {
+	ring := NewKeyring()
+	if err := ring.Add(1, testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := ring.Seal(New("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(blob[len(sealPrefix):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, at := range []int{0, sealHeaderSize, len(raw) - 1} {
+		bad := append([]byte(nil), raw...)
+		bad[at] ^= 1
+		tampered := sealPrefix + base64.RawURLEncoding.EncodeToString(bad)
+		if cause, err := ring.Open(tampered); err == nil || cause != nil {
+			t.Errorf("Open with byte %d flipped = %v, %v; want an error", at, cause, err)
+		}
+	}
+	for _, blob := range []string{"", "te1.", "te1.!!!", "te2." + blob[len(sealPrefix):], blob[:len(sealPrefix)+4]} {
+		if _, err := ring.Open(blob); err == nil {
+			t.Errorf("Open(%q) succeeded", blob)
+		}
+	}
+}
+
+func TestKeyringUnknownKeyID(t *testing.T) {
+	sealer, opener := NewKeyring(), NewKeyring()
+	if err := sealer.Add(7, testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := opener.Add(1, testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := sealer.Seal(New("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opener.Open(blob)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open = %v, want ErrUnknownKey", err)
+	}
+	if !strings.Contains(err.Error(), "id 7") {
+		t.Errorf("error %q does not name the key id", err)
+	}
+}
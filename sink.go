@@ -0,0 +1,10 @@
+package trace_errors
+
+// Sink sends errors to a destination outside the process, such as a log
+// collector. Implementations are safe for concurrent use.
+type Sink interface {
+	// Send delivers err. Sending a nil error does nothing.
+	Send(err error) error
+	// Close releases the resources held by the sink.
+	Close() error
+}
@@ -0,0 +1,75 @@
+package trace_errors
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// sinkConn is the connection of a sink that writes to a network address.
+// It is dialed when the sink is created and dialed again when a write
+// fails. The timeout bounds dialing and every write.
+type sinkConn struct {
+	network string
+	addr    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func dialSink(network, addr string, timeout time.Duration) (*sinkConn, error) {
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &sinkConn{network: network, addr: addr, timeout: timeout, conn: conn}, nil
+}
+
+// write writes packets, one Write each. If a write fails, the connection
+// is closed and the packets are written once more on a new one. On stream
+// sockets the failed write may have sent part of a frame; the old
+// connection is closed right after it, so the receiver sees the partial
+// frame cut off by the end of the connection and the framing of the new
+// connection starts clean. A message whose write failed only after
+// reaching the receiver may arrive twice.
+func (c *sinkConn) write(packets ...[]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		if err := c.writeLocked(packets); err == nil {
+			return nil
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	conn, err := net.DialTimeout(c.network, c.addr, c.timeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return c.writeLocked(packets)
+}
+
+func (c *sinkConn) writeLocked(packets [][]byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	for _, p := range packets {
+		if _, err := c.conn.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *sinkConn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
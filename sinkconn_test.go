@@ -0,0 +1,52 @@
+package trace_errors
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSinkConnRedial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := dialSink("tcp", ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	first, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	// A write on the broken connection fails and is retried on a new one.
+	c.conn.Close()
+	if err := c.write([]byte("he"), []byte("llo")); err != nil {
+		t.Fatal(err)
+	}
+	second, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(second, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("new connection got %q, want %q", got, "hello")
+	}
+
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
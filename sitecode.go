@@ -0,0 +1,75 @@
+package trace_errors
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"regexp"
+	"strings"
+)
+
+// PublicMessageFormat is the message PublicMessage shows to users; %s is
+// replaced by the site code.
+var PublicMessageFormat = "Internal error (reference %s)"
+
+// siteEncoding is Crockford's base32 alphabet, which avoids letters that
+// are easily confused when read out loud.
+var siteEncoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// closureSuffix matches the names the compiler gives to closures and
+// wrappers inside a function.
+var closureSuffix = regexp.MustCompile(`(\.(func|gowrap|deferwrap)\d+|\.\d+)+$`)
+
+// SiteCode returns a short code identifying where err came from: the site
+// of the innermost TraceError in its chain. The code depends only on the
+// package, the function and the message template (the format of Newf and
+// Wrapf, the message otherwise), not on line numbers, so it stays the same
+// across builds until the site moves to another function or its message
+// changes. It returns "" if the chain holds no TraceError with a frame.
+func SiteCode(err error) string {
+	links := chain(err)
+	for i := len(links) - 1; i >= 0; i-- {
+		e, ok := links[i].(*TraceError)
+		if !ok || e.Frame == "" {
+			continue
+		}
+		return e.siteCode()
+	}
+	return ""
+}
+
+func (e *TraceError) siteCode() string {
+	if e.site != "" {
+		return e.site
+	}
+	function, _, _ := splitFrame(e.Frame)
+	template := e.template
+	if template == "" {
+		template = e.Msg
+	}
+	pkg := goPackage(function)
+	return ComputeSiteCode(pkg, siteFunction(pkg, function), template)
+}
+
+// siteFunction returns the name of function within pkg, with the names
+// of closures folded into the enclosing function.
+func siteFunction(pkg, function string) string {
+	name := strings.TrimPrefix(function, pkg+".")
+	return closureSuffix.ReplaceAllString(name, "")
+}
+
+// ComputeSiteCode returns the site code of a capture site in the package
+// with import path pkg, inside function (as named by the runtime without
+// the package, such as "Load" or "(*Store).Get"), with the given message
+// template. It is exported for tools that list sites statically.
+func ComputeSiteCode(pkg, function, template string) string {
+	sum := sha256.Sum256([]byte(pkg + "\x00" + function + "\x00" + template))
+	code := siteEncoding.EncodeToString(sum[:5])
+	return code[:4] + "-" + code[4:]
+}
+
+// PublicMessage returns a message for err that is safe to show to users:
+// it reveals nothing of the error but its site code, which support can use
+// to find where it came from.
+func PublicMessage(err error) string {
+	return strings.Replace(PublicMessageFormat, "%s", SiteCode(err), 1)
+}
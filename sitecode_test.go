@@ -0,0 +1,96 @@
+package trace_errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const testPkg = "github.com/apepenkov/trace_errors"
+
+func genericSiteCode[T any](v T) error {
+	return Newf("generic %v", v)
+}
+
+type siteBox[T any] struct{ v T }
+
+func (b *siteBox[T]) err() error {
+	return Newf("boxed %v", b.v)
+}
+
+func TestSiteFunction(t *testing.T) {
+	tests := []struct{ function, want string }{
+		{testPkg + ".Load", "Load"},
+		{testPkg + ".(*Store).Get", "(*Store).Get"},
+		{testPkg + ".Load.func1", "Load"},
+		{testPkg + ".Load.func2.3", "Load"},
+		{testPkg + ".Load.func1.gowrap2", "Load"},
+		{testPkg + ".Load.deferwrap1", "Load"},
+		{testPkg + ".init.func4", "init"},
+		{testPkg + ".Map[...]", "Map[...]"},
+		{testPkg + ".Map[...].func1", "Map[...]"},
+		{testPkg + ".(*Set[...]).Add.func1", "(*Set[...]).Add"},
+		{testPkg + ".Version2", "Version2"},
+	}
+	for _, tt := range tests {
+		if got := siteFunction(testPkg, tt.function); got != tt.want {
+			t.Errorf("siteFunction(%q) = %q, want %q", tt.function, got, tt.want)
+		}
+	}
+}
+
+func TestSiteCodeFolding(t *testing.T) {
+	direct := New("same message")
+	inClosure := func() error { return New("same message") }()
+	if SiteCode(direct) != SiteCode(inClosure) {
+		t.Errorf("closure code %s differs from its function's %s", SiteCode(inClosure), SiteCode(direct))
+	}
+	if want := ComputeSiteCode(testPkg, "TestSiteCodeFolding", "same message"); SiteCode(direct) != want {
+		t.Errorf("SiteCode = %s, want %s", SiteCode(direct), want)
+	}
+	if SiteCode(New("other message")) == SiteCode(direct) {
+		t.Error("different messages share a code")
+	}
+
+	if got, want := SiteCode(genericSiteCode(1)), ComputeSiteCode(testPkg, "genericSiteCode[...]", "generic %v"); got != want {
+		t.Errorf("generic function: %s, want %s", got, want)
+	}
+	if SiteCode(genericSiteCode(1)) != SiteCode(genericSiteCode("x")) {
+		t.Error("instantiations of a generic function have different codes")
+	}
+	if got, want := SiteCode((&siteBox[int]{1}).err()), ComputeSiteCode(testPkg, "(*siteBox[...]).err", "boxed %v"); got != want {
+		t.Errorf("generic method: %s, want %s", got, want)
+	}
+}
+
+func TestSiteCodeChain(t *testing.T) {
+	inner := Newf("user %d not found", 42)
+	err := fmt.Errorf("handler: %w", Wrap(inner, "loading"))
+	if SiteCode(err) != SiteCode(inner) {
+		t.Error("SiteCode is not the innermost site's")
+	}
+	if SiteCode(inner) != SiteCode(Newf("user %d not found", 7)) {
+		t.Error("the code depends on the formatted arguments")
+	}
+	if got := SiteCode(errors.New("plain")); got != "" {
+		t.Errorf("SiteCode of a foreign error = %q", got)
+	}
+	if got := SiteCode(nil); got != "" {
+		t.Errorf("SiteCode(nil) = %q", got)
+	}
+}
+
+func TestPublicMessage(t *testing.T) {
+	err := Wrap(New("password for admin is hunter2"), "login")
+	code := SiteCode(err)
+	if got, want := PublicMessage(err), "Internal error (reference "+code+")"; got != want {
+		t.Errorf("PublicMessage = %q, want %q", got, want)
+	}
+
+	saved := PublicMessageFormat
+	defer func() { PublicMessageFormat = saved }()
+	PublicMessageFormat = "Something went wrong. Quote %s to support."
+	if got := PublicMessage(err); got != "Something went wrong. Quote "+code+" to support." {
+		t.Errorf("PublicMessage with a custom format = %q", got)
+	}
+}
@@ -0,0 +1,105 @@
+package trace_errors
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// snapshotsOn mirrors snapshots.enabled so that the constructors can skip
+// the lock when snapshots are off.
+var snapshotsOn int32
+
+// RuntimeSnapshot is the state of the Go runtime when an error was created.
+type RuntimeSnapshot struct {
+	Time       time.Time     `json:"time"`
+	Goroutines int           `json:"goroutines"`
+	HeapInUse  uint64        `json:"heap_in_use"`
+	NumGC      uint32        `json:"num_gc"`
+	PauseTotal time.Duration `json:"gc_pause_total"`
+	LastPause  time.Duration `json:"gc_last_pause"`
+	OpenFiles  int           `json:"open_files"` // -1 if unknown
+	GOMAXPROCS int           `json:"gomaxprocs"`
+}
+
+// String formats the snapshot on one line.
+func (s *RuntimeSnapshot) String() string {
+	return fmt.Sprintf("goroutines=%d heap_in_use=%d num_gc=%d gc_pause_total=%s gc_last_pause=%s open_files=%d gomaxprocs=%d at %s",
+		s.Goroutines, s.HeapInUse, s.NumGC, s.PauseTotal, s.LastPause, s.OpenFiles, s.GOMAXPROCS, s.Time.Format(time.RFC3339Nano))
+}
+
+var snapshots struct {
+	sync.Mutex
+	enabled  bool
+	interval time.Duration
+	last     *RuntimeSnapshot
+}
+
+// SetRuntimeSnapshots turns the attachment of a RuntimeSnapshot to new
+// TraceErrors on or off. Reading the runtime state briefly stops the
+// world, so at most one snapshot is taken per minInterval; errors created
+// in between share the latest one, whose Time tells how old it is.
+// Errors whose cause already carries a snapshot get none.
+func SetRuntimeSnapshots(enabled bool, minInterval time.Duration) {
+	snapshots.Lock()
+	defer snapshots.Unlock()
+	snapshots.enabled = enabled
+	snapshots.interval = minInterval
+	snapshots.last = nil
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&snapshotsOn, v)
+}
+
+// runtimeSnapshot returns the snapshot to attach to a new error, or nil
+// if snapshots are off.
+func runtimeSnapshot() *RuntimeSnapshot {
+	snapshots.Lock()
+	defer snapshots.Unlock()
+	if !snapshots.enabled {
+		return nil
+	}
+	now := time.Now()
+	if snapshots.last != nil && now.Sub(snapshots.last.Time) < snapshots.interval {
+		return snapshots.last
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	snapshots.last = &RuntimeSnapshot{
+		Time:       now,
+		Goroutines: runtime.NumGoroutine(),
+		HeapInUse:  ms.HeapInuse,
+		NumGC:      ms.NumGC,
+		PauseTotal: time.Duration(ms.PauseTotalNs),
+		LastPause:  time.Duration(ms.PauseNs[(ms.NumGC+255)%256]),
+		OpenFiles:  openFiles(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+	}
+	return snapshots.last
+}
+
+// innermostSnapshot returns the RuntimeSnapshot of the innermost
+// TraceError in links that has one.
+func innermostSnapshot(links []error) *RuntimeSnapshot {
+	for i := len(links) - 1; i >= 0; i-- {
+		if e, ok := links[i].(*TraceError); ok && e.Runtime != nil {
+			return e.Runtime
+		}
+	}
+	return nil
+}
+
+// hasSnapshot reports whether a TraceError in the chain of err carries a
+// RuntimeSnapshot.
+func hasSnapshot(err error) bool {
+	for _, link := range chain(err) {
+		if e, ok := link.(*TraceError); ok && e.Runtime != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package trace_errors
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxStackDepth is the number of frames kept by full stack capture.
+const maxStackDepth = 64
+
+// fullStacks is non-zero when constructors record the full call stack.
+var fullStacks int32
+
+// SetFullStackCapture turns recording of the full call stack of every
+// TraceError on or off. It is off by default because a stack costs more
+// to capture and keep than the single frame always recorded.
+func SetFullStackCapture(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&fullStacks, v)
+}
+
+// stack is a captured call stack stored relative to the stack of the
+// error's cause. Wrapping happens further up the same call path, so the
+// outermost frames of a link's stack usually equal those of its cause;
+// only the frames that differ are copied, and the rest is a slice of
+// frames already held for the cause, so that building and rendering a
+// stack never walks the chain.
+type stack struct {
+	own  []uintptr // frames not shared with the cause, innermost first
+	tail []uintptr // outermost frames, shared with the cause's stack
+}
+
+// pcs reconstructs the full stack, innermost first.
+func (s *stack) pcs() []uintptr {
+	if len(s.tail) == 0 {
+		return s.own
+	}
+	if len(s.own) == 0 {
+		return s.tail
+	}
+	out := make([]uintptr, 0, len(s.own)+len(s.tail))
+	out = append(out, s.own...)
+	return append(out, s.tail...)
+}
+
+// suffix returns the outermost n frames of s. They are the end of the
+// tail when it is long enough, and otherwise the interned full stack.
+func (s *stack) suffix(n int) []uintptr {
+	if n <= len(s.tail) {
+		return s.tail[len(s.tail)-n:]
+	}
+	full := internStack(s.pcs())
+	return full[len(full)-n:]
+}
+
+// maxInternedStacks bounds the number of distinct stacks kept by
+// internStack. Once reached, new stacks are stored uninterned.
+const maxInternedStacks = 4096
+
+// pcBuffers holds the buffers runtime.Callers writes into.
+var pcBuffers = sync.Pool{
+	New: func() interface{} { return new([maxStackDepth]uintptr) },
+}
+
+var (
+	internedMu sync.RWMutex
+	interned   = make(map[uint64][]uintptr)
+)
+
+// captureStack records the stack of the caller skip frames above its own
+// caller, sharing the common outermost frames with the stack of cause.
+func captureStack(skip int, cause error) *stack {
+	buf := pcBuffers.Get().(*[maxStackDepth]uintptr)
+	defer pcBuffers.Put(buf)
+	full := buf[:runtime.Callers(skip+2, buf[:])]
+	s := &stack{}
+	if parent := causeStack(cause); parent != nil {
+		k := sharedFrames(full, parent)
+		if k > 0 {
+			s.tail = parent.suffix(k)
+			full = full[:len(full)-k]
+		}
+	}
+	s.own = internStack(full)
+	return s
+}
+
+// sharedFrames returns the number of outermost frames full has in common
+// with parent, comparing the tail of parent before its own frames.
+func sharedFrames(full []uintptr, parent *stack) int {
+	k := 0
+	for _, part := range [][]uintptr{parent.tail, parent.own} {
+		for i := len(part) - 1; i >= 0; i-- {
+			if k == len(full) || full[len(full)-1-k] != part[i] {
+				return k
+			}
+			k++
+		}
+	}
+	return k
+}
+
+// internStack returns a slice equal to pcs that does not alias it. Errors
+// created over and over on the same path share a single copy.
+func internStack(pcs []uintptr) []uintptr {
+	if len(pcs) == 0 {
+		return nil
+	}
+	h := uint64(14695981039346656037)
+	for _, pc := range pcs {
+		h ^= uint64(pc)
+		h *= 1099511628211
+	}
+
+	internedMu.RLock()
+	cached, ok := interned[h]
+	full := len(interned) >= maxInternedStacks
+	internedMu.RUnlock()
+	if ok && equalPCs(cached, pcs) {
+		return cached
+	}
+
+	own := make([]uintptr, len(pcs))
+	copy(own, pcs)
+	if ok || full {
+		// The slot belongs to another stack or there is no room left:
+		// nothing to store, so skip the write lock.
+		return own
+	}
+	internedMu.Lock()
+	if _, taken := interned[h]; !taken && len(interned) < maxInternedStacks {
+		interned[h] = own
+	}
+	internedMu.Unlock()
+	return own
+}
+
+func equalPCs(a, b []uintptr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// causeStack returns the stack of the first TraceError in the chain of
+// err that has one. It walks the chain only as far as that link, which
+// is usually err itself, so wrapping does not cost the length of the
+// chain.
+func causeStack(err error) *stack {
+	for i := 0; err != nil && i < maxChainLength; i++ {
+		if e, ok := err.(*TraceError); ok && e != nil && e.stack != nil {
+			return e.stack
+		}
+		err = unwrapOne(err)
+	}
+	return nil
+}
+
+// Stack returns the full call stack recorded when e was created, innermost
+// first, or nil if full stack capture was off.
+func (e *TraceError) Stack() []StackFrame {
+	if e.stack == nil {
+		return nil
+	}
+	pcs := e.stack.pcs()
+	frames := make([]StackFrame, 0, len(pcs))
+	it := runtime.CallersFrames(pcs)
+	for {
+		f, more := it.Next()
+		frames = append(frames, StackFrame{
+			Language: "go",
+			Module:   goPackage(f.Function),
+			Function: f.Function,
+			File:     f.File,
+			Line:     f.Line,
+		})
+		if !more {
+			break
+		}
+	}
+	return frames
+}
+
+// FullStackTrace returns the full call stack of the innermost TraceError in
+// the chain of err that has one, formatted like StackTrace.
+func FullStackTrace(err error) string {
+	frames := innermostStack(err)
+	lines := make([]string, len(frames))
+	for i, f := range frames {
+		lines[i] = f.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func innermostStack(err error) []StackFrame {
+	links := chain(err)
+	for i := len(links) - 1; i >= 0; i-- {
+		if e, ok := links[i].(*TraceError); ok && e.stack != nil {
+			return e.Stack()
+		}
+	}
+	return nil
+}
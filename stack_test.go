@@ -0,0 +1,215 @@
+package trace_errors
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// captureBoth returns the stack captured by captureStack together with
+// the frames runtime.Callers reports at the same point. The innermost
+// frame differs in its pc, being a different call in captureBoth.
+func captureBoth(cause error) (*stack, []uintptr) {
+	var buf [maxStackDepth]uintptr
+	want := buf[:runtime.Callers(1, buf[:])]
+	return captureStack(0, cause), want
+}
+
+// buildStacks wraps depth times on the way back from a recursion depth
+// calls deep, returning the outermost link and the frames each link
+// captured, innermost link first.
+func buildStacks(depth int) (*TraceError, [][]uintptr) {
+	if depth == 0 {
+		s, want := captureBoth(nil)
+		return &TraceError{stack: s}, [][]uintptr{want}
+	}
+	inner, wants := buildStacks(depth - 1)
+	s, want := captureBoth(inner)
+	return &TraceError{Err: inner, stack: s}, append(wants, want)
+}
+
+func TestStackPCs(t *testing.T) {
+	for _, depth := range []int{0, 1, 10, maxStackDepth + 30} {
+		outer, wants := buildStacks(depth)
+		shared := 0
+		link := outer
+		for i := len(wants) - 1; i >= 0; i-- {
+			got, want := link.stack.pcs(), wants[i]
+			if len(got) != len(want) {
+				t.Fatalf("depth %d, link %d: rebuilt %d frames, captured %d", depth, i, len(got), len(want))
+			}
+			if f := runtime.FuncForPC(got[0]); f == nil || f.Name() != "github.com/apepenkov/trace_errors.captureBoth" {
+				t.Errorf("depth %d, link %d: innermost frame is %v", depth, i, f)
+			}
+			for j := 1; j < len(got); j++ {
+				if got[j] != want[j] {
+					t.Errorf("depth %d, link %d: frame %d is %#x, want %#x", depth, i, j, got[j], want[j])
+					break
+				}
+			}
+			if len(link.stack.tail) > 0 {
+				shared++
+			}
+			next, _ := link.Err.(*TraceError)
+			link = next
+		}
+		// Once stacks are cut, the outermost frames of a link and of its
+		// cause no longer line up, so not every link shares.
+		if depth <= maxStackDepth/2 && shared != depth {
+			t.Errorf("depth %d: %d links share frames with their cause, want %d", depth, shared, depth)
+		}
+		if depth > maxStackDepth && len(wants[0]) != maxStackDepth {
+			t.Errorf("depth %d: captured %d frames, want the stack cut at %d", depth, len(wants[0]), maxStackDepth)
+		}
+	}
+}
+
+func TestFullStackCapture(t *testing.T) {
+	SetFullStackCapture(true)
+	defer SetFullStackCapture(false)
+	err := deepWrap(maxStackDepth+10, 3).(*TraceError)
+	frames := err.Stack()
+	if len(frames) != maxStackDepth {
+		t.Fatalf("got %d frames, want %d", len(frames), maxStackDepth)
+	}
+	if frames[0].Function != "github.com/apepenkov/trace_errors.deepWrap" {
+		t.Errorf("innermost frame is %s", frames[0].Function)
+	}
+
+	SetFullStackCapture(false)
+	if err := New("off").(*TraceError); err.Stack() != nil {
+		t.Error("stack captured while capture is off")
+	}
+}
+
+func TestWrapCostIndependentOfChainLength(t *testing.T) {
+	SetFullStackCapture(true)
+	defer SetFullStackCapture(false)
+	shallow := New("leaf")
+	deep := shallow
+	for i := 0; i < 1000; i++ {
+		deep = Wrap(deep, "wrap")
+	}
+	want := testing.AllocsPerRun(100, func() { _ = Wrap(shallow, "outer") })
+	if got := testing.AllocsPerRun(100, func() { _ = Wrap(deep, "outer") }); got != want {
+		t.Errorf("wrapping a 1000-link chain allocates %v times, a 1-link chain %v", got, want)
+	}
+}
+
+func TestInternStackFullSkipsWriteLock(t *testing.T) {
+	defer withoutInterning()()
+	pcs := []uintptr{1, 2, 3}
+	// Holding a read lock makes any attempt to take the write lock block.
+	internedMu.RLock()
+	done := make(chan []uintptr)
+	go func() { done <- internStack(pcs) }()
+	select {
+	case got := <-done:
+		internedMu.RUnlock()
+		if !equalPCs(got, pcs) || &got[0] == &pcs[0] {
+			t.Errorf("internStack returned %v, want a copy of %v", got, pcs)
+		}
+	case <-time.After(5 * time.Second):
+		internedMu.RUnlock()
+		<-done
+		t.Error("internStack took the write lock with the table full")
+	}
+}
+
+// deepWrap creates an error depth calls deep and wraps it on the way back
+// in the wraps innermost calls.
+func deepWrap(depth, wraps int) error {
+	if depth == 0 {
+		return New("deep")
+	}
+	err := deepWrap(depth-1, wraps)
+	if depth <= wraps {
+		err = Wrap(err, "wrap")
+	}
+	return err
+}
+
+func BenchmarkDeepChainStackOff(b *testing.B) {
+	benchmarkDeepChain(b, false)
+}
+
+func BenchmarkDeepChainStackOn(b *testing.B) {
+	benchmarkDeepChain(b, true)
+}
+
+// benchmarkDeepChain builds chains of 20 wraps 40 calls deep and reports
+// the heap they retain per chain.
+func benchmarkDeepChain(b *testing.B, capture bool) {
+	SetFullStackCapture(capture)
+	defer SetFullStackCapture(false)
+	chains := make([]error, b.N)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range chains {
+		chains[i] = deepWrap(40, 20)
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/float64(b.N), "retained-B/op")
+	runtime.KeepAlive(chains)
+}
+
+func BenchmarkNewParallel(b *testing.B) {
+	benchmarkCaptureModes(b, func() error { return New("failed") })
+}
+
+func BenchmarkWrapParallel(b *testing.B) {
+	cause := New("cause")
+	benchmarkCaptureModes(b, func() error { return Wrap(cause, "failed") })
+}
+
+// benchmarkCaptureModes runs create on all procs with full stack capture
+// off, on with every stack copied, and on with stacks interned.
+func benchmarkCaptureModes(b *testing.B, create func() error) {
+	modes := []struct {
+		name            string
+		capture, intern bool
+	}{
+		{"capture=off", false, false},
+		{"capture=on", true, false},
+		{"capture=on,interned", true, true},
+	}
+	for _, m := range modes {
+		m := m
+		b.Run(m.name, func(b *testing.B) {
+			SetFullStackCapture(m.capture)
+			defer SetFullStackCapture(false)
+			if !m.intern {
+				defer withoutInterning()()
+			}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_ = create()
+				}
+			})
+		})
+	}
+}
+
+// withoutInterning fills the interned stacks with placeholders, so that
+// internStack copies every stack, and returns a function undoing it.
+func withoutInterning() (restore func()) {
+	full := make(map[uint64][]uintptr, maxInternedStacks)
+	for i := 0; i < maxInternedStacks; i++ {
+		full[uint64(i)] = nil
+	}
+	internedMu.Lock()
+	saved := interned
+	interned = full
+	internedMu.Unlock()
+	return func() {
+		internedMu.Lock()
+		interned = saved
+		internedMu.Unlock()
+	}
+}
@@ -0,0 +1,171 @@
+package trace_errors
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogSDID is the SD-ID of the structured data element describing the
+// error. 32473 is the private enterprise number reserved for examples.
+const syslogSDID = "traceerr@32473"
+
+// syslogMinDatagram is the message size RFC 5424 requires every receiver
+// to accept, and the smallest MaxDatagramSize allowed.
+const syslogMinDatagram = 480
+
+// SyslogOptions configures a SyslogSink. The zero value uses the defaults
+// documented on the fields.
+type SyslogOptions struct {
+	// Facility is the syslog facility, 0 to 23. Nil means 1, user-level
+	// messages; it is a pointer so that 0, kernel messages, can be chosen.
+	Facility *int
+	// Severity is the syslog severity, 0 to 7. Nil means 3, error
+	// conditions; it is a pointer so that 0, emergency, can be chosen.
+	Severity *int
+	// Hostname is the HOSTNAME field. Empty means os.Hostname.
+	Hostname string
+	// AppName is the APP-NAME field. Empty means the program name.
+	AppName string
+	// MsgID is the MSGID field. Empty means "error".
+	MsgID string
+	// Timeout bounds dialing and every write. Zero means 5 seconds.
+	Timeout time.Duration
+	// MaxDatagramSize is the size datagram messages are truncated to, at
+	// least 480 bytes. Zero means 8192 bytes. Messages on stream sockets
+	// are not truncated.
+	MaxDatagramSize int
+}
+
+// SyslogSink writes errors as RFC 5424 messages. The message chain is the
+// MSG and the code, fingerprint and innermost frame are a STRUCTURED-DATA
+// element. On stream sockets ("tcp", "unix") messages are framed by octet
+// counting as described in RFC 6587.
+type SyslogSink struct {
+	opts     SyslogOptions
+	priority int
+	stream   bool
+	conn     *sinkConn
+}
+
+// NewSyslogSink connects to the syslog server at addr on network, one of
+// "udp", "tcp", "unix" or "unixgram". It fails if an option is out of
+// range.
+func NewSyslogSink(network, addr string, opts SyslogOptions) (*SyslogSink, error) {
+	facility, severity := 1, 3
+	if opts.Facility != nil {
+		facility = *opts.Facility
+	}
+	if opts.Severity != nil {
+		severity = *opts.Severity
+	}
+	if facility < 0 || facility > 23 {
+		return nil, fmt.Errorf("trace_errors: syslog facility %d is not between 0 and 23", facility)
+	}
+	if severity < 0 || severity > 7 {
+		return nil, fmt.Errorf("trace_errors: syslog severity %d is not between 0 and 7", severity)
+	}
+	if opts.MaxDatagramSize != 0 && opts.MaxDatagramSize < syslogMinDatagram {
+		return nil, fmt.Errorf("trace_errors: syslog MaxDatagramSize %d is less than %d", opts.MaxDatagramSize, syslogMinDatagram)
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+	if opts.AppName == "" {
+		opts.AppName = filepath.Base(os.Args[0])
+	}
+	if opts.MsgID == "" {
+		opts.MsgID = "error"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxDatagramSize == 0 {
+		opts.MaxDatagramSize = 8192
+	}
+	conn, err := dialSink(network, addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{
+		opts:     opts,
+		priority: facility*8 + severity,
+		stream:   network == "tcp" || network == "tcp4" || network == "tcp6" || network == "unix",
+		conn:     conn,
+	}, nil
+}
+
+// Send implements Sink. A failed write is retried once on a new
+// connection, which may deliver the message twice.
+func (s *SyslogSink) Send(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := s.format(err, time.Now())
+	if s.stream {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	} else if len(msg) > s.opts.MaxDatagramSize {
+		msg = strings.ToValidUTF8(msg[:s.opts.MaxDatagramSize], "")
+	}
+	return s.conn.write([]byte(msg))
+}
+
+// Close implements Sink.
+func (s *SyslogSink) Close() error {
+	return s.conn.close()
+}
+
+// format returns the RFC 5424 message for err, without framing.
+func (s *SyslogSink) format(err error, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s ",
+		s.priority,
+		now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeader(s.opts.Hostname, 255),
+		syslogHeader(s.opts.AppName, 48),
+		os.Getpid(),
+		syslogHeader(s.opts.MsgID, 32),
+	)
+
+	b.WriteString("[" + syslogSDID)
+	if code := Code(err); code != "" {
+		writeSDParam(&b, "code", code)
+	}
+	writeSDParam(&b, "fingerprint", Fingerprint(err))
+	if frame := innermostFrame(chain(err)); frame != "" {
+		function, file, line := splitFrame(frame)
+		writeSDParam(&b, "func", function)
+		writeSDParam(&b, "file", file)
+		writeSDParam(&b, "line", strconv.Itoa(line))
+	}
+	b.WriteString("] \xef\xbb\xbf")
+	b.WriteString(message(err))
+	return b.String()
+}
+
+// syslogHeader makes s a valid header field: printable US-ASCII without
+// spaces, at most max characters, "-" when empty.
+func syslogHeader(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func writeSDParam(b *strings.Builder, name, value string) {
+	b.WriteString(" " + name + `="` + sdEscaper.Replace(value) + `"`)
+}
@@ -0,0 +1,163 @@
+package trace_errors
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s, err := NewSyslogSink("tcp", ln.Addr().String(), SyslogOptions{AppName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msgs := []string{"first", "second\nwith a newline", "third"}
+	for _, m := range msgs {
+		if serr := s.Send(New(m)); serr != nil {
+			t.Fatal(serr)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range msgs {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			t.Fatalf("bad frame length %q", length)
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(frame), "\xef\xbb\xbf"+want) {
+			t.Errorf("frame %q does not end with the message %q", frame, want)
+		}
+	}
+}
+
+func TestSyslogStructuredData(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, err := NewSyslogSink("udp", pc.LocalAddr().String(), SyslogOptions{Hostname: "host", AppName: "app", MsgID: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if serr := s.Send(WithCode(New("failed"), `a"b\c]d`)); serr != nil {
+		t.Fatal(serr)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1<<16)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if !strings.HasPrefix(got, "<11>1 ") {
+		t.Errorf("message %q does not start with the priority and version", got)
+	}
+	for _, want := range []string{
+		" host app ",
+		" id [traceerr@32473 ",
+		` code="a\"b\\c\]d"`,
+		` func="github.com/apepenkov/trace_errors.TestSyslogStructuredData"`,
+		"] \xef\xbb\xbffailed",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message %q does not contain %q", got, want)
+		}
+	}
+}
+
+func TestSyslogDatagramTruncated(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, err := NewSyslogSink("udp", pc.LocalAddr().String(), SyslogOptions{MaxDatagramSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if serr := s.Send(New(strings.Repeat("é", 1000))); serr != nil {
+		t.Fatal(serr)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1<<16)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n > 512 {
+		t.Errorf("got a %d byte datagram, want at most 512", n)
+	}
+	if !strings.HasSuffix(string(buf[:n]), "é") {
+		t.Error("truncation split a character")
+	}
+}
+
+func TestSyslogOptions(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	addr := pc.LocalAddr().String()
+
+	zero, big, negative := 0, 24, -1
+	for _, opts := range []SyslogOptions{
+		{Facility: &big},
+		{Facility: &negative},
+		{Severity: &negative},
+		{MaxDatagramSize: -1},
+		{MaxDatagramSize: 100},
+	} {
+		if s, err := NewSyslogSink("udp", addr, opts); err == nil {
+			s.Close()
+			t.Errorf("NewSyslogSink(%+v) succeeded", opts)
+		}
+	}
+
+	s, err := NewSyslogSink("udp", addr, SyslogOptions{Facility: &zero, Severity: &zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if serr := s.Send(New("kernel panic")); serr != nil {
+		t.Fatal(serr)
+	}
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1<<16)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); !strings.HasPrefix(got, "<0>1 ") {
+		t.Errorf("message %q does not start with priority 0", got)
+	}
+}
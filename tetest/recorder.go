@@ -0,0 +1,210 @@
+package tetest
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	te "github.com/apepenkov/trace_errors"
+)
+
+// recorderDepth is the number of frames first searched for the test
+// function; deeper stacks are searched in full.
+const recorderDepth = 128
+
+// Recorder captures the TraceErrors created while a test runs, through
+// the package's creation hooks, and checks them against filter
+// expressions (see trace_errors.ParseFilter). Every link of a chain is
+// recorded as it is created, so a filter matching a chain also matches
+// the links wrapping it.
+type Recorder struct {
+	tb       testing.TB
+	function string // the test function, or "" to record everything
+
+	mu     sync.Mutex
+	errs   []*te.TraceError
+	others []*te.TraceError // created elsewhere while recording
+}
+
+// Record starts recording the TraceErrors created by the calling test and
+// stops when it ends. Only errors created on a call stack that passes
+// through the function calling Record, or through a closure defined in
+// it such as a t.Run subtest or a goroutine started with go func, are
+// kept; this keeps the errors of tests running in parallel apart. Errors
+// created elsewhere, for example on a goroutine running a named function,
+// are not matched by Find and the Expect methods but are listed in their
+// failure messages; use RecordAll to record them as well.
+func Record(tb testing.TB) *Recorder {
+	tb.Helper()
+	function := ""
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			function = fn.Name()
+		}
+	}
+	return start(tb, function)
+}
+
+// RecordAll starts recording every TraceError created in the process until
+// the test ends. It must not be used by tests that run in parallel with
+// others creating errors.
+func RecordAll(tb testing.TB) *Recorder {
+	tb.Helper()
+	return start(tb, "")
+}
+
+func start(tb testing.TB, function string) *Recorder {
+	r := &Recorder{tb: tb, function: function}
+	tb.Cleanup(te.AddHook(r.record))
+	return r
+}
+
+func (r *Recorder) record(e *te.TraceError) {
+	mine := r.function == "" || onStack(r.function)
+	r.mu.Lock()
+	if mine {
+		r.errs = append(r.errs, e)
+	} else {
+		r.others = append(r.others, e)
+	}
+	r.mu.Unlock()
+}
+
+// onStack reports whether function, or a closure defined in it, is on the
+// calling goroutine's stack.
+func onStack(function string) bool {
+	pcs := make([]uintptr, recorderDepth)
+	for {
+		n := runtime.Callers(3, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if f.Function == function || strings.HasPrefix(f.Function, function+".") {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+// Errors returns the recorded errors in the order they were created.
+func (r *Recorder) Errors() []*te.TraceError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*te.TraceError(nil), r.errs...)
+}
+
+// Unattributed returns the errors created while recording that were not
+// attributed to the test, in the order they were created. They may come
+// from tests running in parallel or from goroutines the test started.
+func (r *Recorder) Unattributed() []*te.TraceError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*te.TraceError(nil), r.others...)
+}
+
+// Reset forgets the errors recorded so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.errs = nil
+	r.others = nil
+	r.mu.Unlock()
+}
+
+// Find returns the recorded errors matching the filter expression. It
+// fails the test if the expression is malformed.
+func (r *Recorder) Find(filter string) []*te.TraceError {
+	r.tb.Helper()
+	f, err := te.ParseFilter(filter)
+	if err != nil {
+		r.tb.Fatal(err)
+		return nil
+	}
+	var found []*te.TraceError
+	for _, e := range r.Errors() {
+		if f.Match(e) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// ExpectReported fails the test unless an error matching the filter
+// expression was recorded, and returns the first one. For example:
+//
+//	rec.ExpectReported(`code = "NotFound" and func ~ "*.(*Store).Get"`)
+func (r *Recorder) ExpectReported(filter string) *te.TraceError {
+	r.tb.Helper()
+	found := r.Find(filter)
+	if len(found) == 0 {
+		r.tb.Fatalf("no recorded error matches %s\n%s", filter, r.describe())
+		return nil
+	}
+	return found[0]
+}
+
+// ExpectNotReported fails the test if an error matching the filter
+// expression was recorded.
+func (r *Recorder) ExpectNotReported(filter string) {
+	r.tb.Helper()
+	if found := r.Find(filter); len(found) > 0 {
+		r.tb.Fatalf("%d recorded errors match %s\n%s", len(found), filter, r.describe())
+	}
+}
+
+// ExpectNone fails the test if any error was recorded. Errors that were
+// not attributed to the test are logged without failing it.
+func (r *Recorder) ExpectNone() {
+	r.tb.Helper()
+	if errs := r.Errors(); len(errs) > 0 {
+		r.tb.Fatalf("expected no errors, recorded %d\n%s", len(errs), r.describe())
+		return
+	}
+	if others := r.Unattributed(); len(others) > 0 {
+		r.tb.Logf("no errors recorded, %d created elsewhere\n%s", len(others), r.describe())
+	}
+}
+
+// describe lists the recorded and the unattributed errors for failure
+// messages.
+func (r *Recorder) describe() string {
+	var b strings.Builder
+	errs := r.Errors()
+	if len(errs) == 0 {
+		b.WriteString("recorded: none")
+	} else {
+		b.WriteString("recorded:")
+		writeErrors(&b, errs)
+	}
+	if others := r.Unattributed(); len(others) > 0 {
+		fmt.Fprintf(&b, "\nnot attributed to %s (other tests, or goroutines it started?):", r.function)
+		writeErrors(&b, others)
+	}
+	return b.String()
+}
+
+func writeErrors(b *strings.Builder, errs []*te.TraceError) {
+	for _, e := range errs {
+		function, _, _ := strings.Cut(e.Frame, "\n")
+		b.WriteString("\n\t")
+		if code := te.Code(e); code != "" {
+			b.WriteString("[" + code + "] ")
+		}
+		b.WriteString(firstLine(e.Msg))
+		b.WriteString(" (" + function + ")")
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
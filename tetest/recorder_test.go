@@ -0,0 +1,80 @@
+package tetest_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	te "github.com/apepenkov/trace_errors"
+	"github.com/apepenkov/trace_errors/tetest"
+)
+
+func TestRecordSubtestsAndGoroutines(t *testing.T) {
+	rec := tetest.Record(t)
+	t.Run("sub", func(t *testing.T) {
+		_ = te.New("from-subtest")
+	})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = te.New("from-goroutine")
+	}()
+	wg.Wait()
+	_ = deep(200)
+
+	rec.ExpectReported(`msg = "from-subtest"`)
+	rec.ExpectReported(`msg = "from-goroutine"`)
+	rec.ExpectReported(`msg = "deep"`)
+}
+
+func TestRecordUnattributed(t *testing.T) {
+	fake := &fakeTB{TB: t}
+	rec := tetest.Record(fake)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go createElsewhere(&wg)
+	wg.Wait()
+
+	if n := len(rec.Unattributed()); n != 1 {
+		t.Fatalf("got %d unattributed errors, want 1", n)
+	}
+	rec.ExpectReported(`msg = "elsewhere"`)
+	if !strings.Contains(fake.fatal, "not attributed") || !strings.Contains(fake.fatal, "elsewhere") {
+		t.Errorf("failure message does not mention the unattributed error:\n%s", fake.fatal)
+	}
+
+	rec.ExpectNone()
+	if !strings.Contains(fake.log, "elsewhere") {
+		t.Errorf("ExpectNone does not log the unattributed error:\n%s", fake.log)
+	}
+}
+
+func createElsewhere(wg *sync.WaitGroup) {
+	defer wg.Done()
+	_ = te.New("elsewhere")
+}
+
+func deep(n int) error {
+	if n == 0 {
+		return te.New("deep")
+	}
+	return deep(n - 1)
+}
+
+// fakeTB records failures instead of failing the test.
+type fakeTB struct {
+	testing.TB
+	fatal, log string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.fatal = fmt.Sprintf(format, args...)
+}
+
+func (f *fakeTB) Logf(format string, args ...interface{}) {
+	f.log = fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,320 @@
+// Package tetest implements support for testing renderers and sinks built on
+// top of trace_errors.
+//
+// It mirrors testing/slogtest: TestRenderer and TestSink run the value under
+// test against a corpus of tricky error chains and report every violation
+// they find as a single joined error.
+package tetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	te "github.com/apepenkov/trace_errors"
+)
+
+// Timeout bounds how long a single case may take before it is reported as
+// hanging. A hanging call is abandoned, not interrupted.
+var Timeout = 5 * time.Second
+
+// hugeMessage is the message of the "huge" case, built once and shared by
+// every copy of the corpus.
+var hugeMessage = "tetest-huge " + strings.Repeat("x", 1<<20)
+
+// Case is one error chain of the conformance corpus.
+type Case struct {
+	// Name identifies the case in violation reports.
+	Name string
+	// Err is the error handed to the renderer or sink.
+	Err error
+	// Contains lists strings that a rendering of Err must include.
+	Contains []string
+}
+
+// Corpus returns a fresh copy of the conformance corpus.
+func Corpus() []Case {
+	cases := []Case{
+		{
+			Name:     "single",
+			Err:      te.New("tetest-single"),
+			Contains: []string{"tetest-single"},
+		},
+		{
+			Name:     "nested",
+			Err:      te.Wrap(te.Wrap(te.New("tetest-leaf"), "tetest-middle"), "tetest-outer"),
+			Contains: []string{"tetest-leaf", "tetest-middle", "tetest-outer"},
+		},
+		{
+			Name:     "foreign-leaf",
+			Err:      te.Wrap(errors.New("tetest-foreign"), "tetest-outer"),
+			Contains: []string{"tetest-foreign", "tetest-outer"},
+		},
+		{
+			Name:     "foreign-wrapper",
+			Err:      fmt.Errorf("tetest-fmt: %w", te.Wrap(te.New("tetest-leaf"), "tetest-inner")),
+			Contains: []string{"tetest-fmt", "tetest-leaf"},
+		},
+		{
+			Name:     "foreign-middle",
+			Err:      te.Wrap(fmt.Errorf("tetest-fmt: %w", te.New("tetest-leaf")), "tetest-outer"),
+			Contains: []string{"tetest-outer", "tetest-fmt", "tetest-leaf"},
+		},
+		{
+			Name:     "multi",
+			Err:      errors.Join(te.New("tetest-a"), te.Wrap(errors.New("tetest-b"), "tetest-c")),
+			Contains: []string{"tetest-a", "tetest-b"},
+		},
+		{
+			Name: "nested-multi",
+			Err: te.Wrap(errors.Join(
+				te.Wrap(te.New("tetest-a"), "tetest-item-1"),
+				te.Wrap(te.New("tetest-b"), "tetest-item-2"),
+			), "tetest-batch"),
+			Contains: []string{"tetest-batch", "tetest-a", "tetest-b"},
+		},
+		{
+			Name:     "nil-cause",
+			Err:      &te.TraceError{Msg: "tetest-nil-cause"},
+			Contains: []string{"tetest-nil-cause"},
+		},
+		{
+			Name: "empty",
+			Err:  &te.TraceError{},
+		},
+		{
+			Name:     "unicode",
+			Err:      te.New("tetest-unicode: ошибка 错误 🔥 \u200b\u202e"),
+			Contains: []string{"tetest-unicode"},
+		},
+		{
+			Name:     "control-characters",
+			Err:      te.New("tetest-control\n\t\r\x00\x1b[31m\"'<>&|"),
+			Contains: []string{"tetest-control"},
+		},
+		{
+			Name:     "huge",
+			Err:      te.New(hugeMessage),
+			Contains: []string{"tetest-huge"},
+		},
+		{
+			Name:     "deep",
+			Err:      deepChain(100),
+			Contains: []string{"tetest-deep-leaf"},
+		},
+		{
+			Name:     "cycle",
+			Err:      cycleChain(),
+			Contains: []string{"tetest-cycle-link"},
+		},
+		{
+			Name:     "trace-cycle",
+			Err:      traceCycle(),
+			Contains: []string{"tetest-trace-cycle-a", "tetest-trace-cycle-b"},
+		},
+		{
+			Name:     "multi-cycle",
+			Err:      multiCycle(),
+			Contains: []string{"tetest-multi-cycle"},
+		},
+		{
+			Name:     "nil-trace-error",
+			Err:      te.Wrap((*te.TraceError)(nil), "tetest-outer"),
+			Contains: []string{"tetest-outer"},
+		},
+		{
+			Name:     "panicking-error",
+			Err:      te.Wrap(panickingError{}, "tetest-outer"),
+			Contains: []string{"tetest-outer"},
+		},
+		{
+			Name:     "nil-receiver",
+			Err:      te.Wrap((*nilReceiverError)(nil), "tetest-outer"),
+			Contains: []string{"tetest-outer"},
+		},
+		{
+			Name:     "panicking-unwrap",
+			Err:      te.Wrap(panickingUnwrapError{}, "tetest-outer"),
+			Contains: []string{"tetest-outer", "tetest-panicking-unwrap"},
+		},
+	}
+	return cases
+}
+
+// TestRenderer checks that render behaves on every case of the corpus: it
+// must not panic, must return in time without an error, and must write
+// output that includes the case's required strings. It also checks that
+// rendering a nil error does not panic.
+func TestRenderer(render func(w io.Writer, err error) error) error {
+	var errs []error
+	for _, c := range append(Corpus(), Case{Name: "nil"}) {
+		c := c
+		var buf bytes.Buffer
+		if err := run(func() error { return render(&buf, c.Err) }); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+			continue
+		}
+		out := buf.String()
+		for _, want := range c.Contains {
+			if !strings.Contains(out, want) {
+				errs = append(errs, fmt.Errorf("%s: output does not contain %q", c.Name, want))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TestSink checks that send behaves on every case of the corpus: it must not
+// panic and must return in time without an error. It also checks that
+// sending a nil error does not panic.
+//
+// If received is not nil, it must return every message the sink's
+// destination has received so far, decoded from the wire format, and
+// TestSink also checks that the messages received for each case include
+// the case's required strings.
+func TestSink(send func(err error) error, received func() []string) error {
+	var errs []error
+	for _, c := range Corpus() {
+		c := c
+		var before int
+		if received != nil {
+			before = len(received())
+		}
+		if err := run(func() error { return send(c.Err) }); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+			continue
+		}
+		if received != nil {
+			if err := awaitContains(received, before, c.Contains); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+			}
+		}
+	}
+	if err := run(func() error { send(nil); return nil }); err != nil {
+		errs = append(errs, fmt.Errorf("nil: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// awaitContains waits until the messages received after the first skip
+// include every string of want, and reports the first string still missing
+// after Timeout.
+func awaitContains(received func() []string, skip int, want []string) error {
+	deadline := time.Now().Add(Timeout)
+	for {
+		msgs := received()
+		if skip > len(msgs) {
+			skip = len(msgs)
+		}
+		got := strings.Join(msgs[skip:], "\n")
+		missing := ""
+		for _, w := range want {
+			if !strings.Contains(got, w) {
+				missing = w
+				break
+			}
+		}
+		if missing == "" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if len(msgs) == skip {
+				return fmt.Errorf("nothing received within %s", Timeout)
+			}
+			return fmt.Errorf("received messages do not contain %q", missing)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// run calls f on its own goroutine, turning a panic or a hang into an error.
+func run(f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- f()
+	}()
+	timer := time.NewTimer(Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("did not return within %s", Timeout)
+	}
+}
+
+func deepChain(depth int) error {
+	err := te.New("tetest-deep-leaf")
+	for i := 0; i < depth; i++ {
+		err = te.Wrapf(err, "tetest-deep-%d", i)
+	}
+	return err
+}
+
+// cycleError is a foreign wrapper whose Unwrap leads back to itself.
+type cycleError struct {
+	next error
+}
+
+func (e *cycleError) Error() string { return "tetest-cycle" }
+
+func (e *cycleError) Unwrap() error { return e.next }
+
+// panickingError is a foreign error whose Error method panics.
+type panickingError struct{}
+
+func (panickingError) Error() string { panic("tetest-panic") }
+
+// nilReceiverError is a foreign error used through a nil pointer; its
+// Error method dereferences the receiver.
+type nilReceiverError struct {
+	msg string
+}
+
+func (e *nilReceiverError) Error() string { return e.msg }
+
+// panickingUnwrapError is a foreign wrapper whose Unwrap method panics.
+type panickingUnwrapError struct{}
+
+func (panickingUnwrapError) Error() string { return "tetest-panicking-unwrap" }
+
+func (panickingUnwrapError) Unwrap() error { panic("tetest-panic") }
+
+func cycleChain() error {
+	c := &cycleError{}
+	link := &te.TraceError{Msg: "tetest-cycle-link", Err: c, Frame: "tetest.cycleChain\n\ttetest.go:0"}
+	c.next = link
+	return link
+}
+
+// traceCycle returns two TraceErrors wrapping each other.
+func traceCycle() error {
+	a := &te.TraceError{Msg: "tetest-trace-cycle-a", Frame: "tetest.traceCycle\n\ttetest.go:0"}
+	b := &te.TraceError{Msg: "tetest-trace-cycle-b", Err: a, Frame: "tetest.traceCycle\n\ttetest.go:0"}
+	a.Err = b
+	return a
+}
+
+// multiCycleError is a multi-error that can list itself among its
+// branches.
+type multiCycleError struct {
+	errs []error
+}
+
+func (e *multiCycleError) Error() string { return "tetest-multi-cycle" }
+
+func (e *multiCycleError) Unwrap() []error { return e.errs }
+
+func multiCycle() error {
+	m := &multiCycleError{}
+	m.errs = []error{te.New("tetest-multi-cycle-leaf"), te.Wrap(m, "tetest-multi-cycle-back"), m}
+	return te.Wrap(m, "tetest-multi-cycle-outer")
+}
@@ -0,0 +1,222 @@
+package tetest_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	te "github.com/apepenkov/trace_errors"
+	"github.com/apepenkov/trace_errors/tetest"
+)
+
+func TestRenderers(t *testing.T) {
+	for _, name := range te.Renderers() {
+		r, _ := te.LookupRenderer(name)
+		for _, stack := range []bool{false, true} {
+			opts := te.RenderOptions{Stack: stack}
+			err := tetest.TestRenderer(func(w io.Writer, err error) error {
+				return r.Render(w, err, opts)
+			})
+			if err != nil {
+				t.Errorf("%s (stack %v):\n%v", name, stack, err)
+			}
+		}
+	}
+}
+
+func TestSinks(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		r := listen(t, network, decodeSyslog)
+		syslog, err := te.NewSyslogSink(network, r.addr, te.SyslogOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer syslog.Close()
+		if err := tetest.TestSink(syslog.Send, r.messages); err != nil {
+			t.Errorf("syslog over %s:\n%v", network, err)
+		}
+
+		r = listen(t, network, decodeGELF)
+		gelf, err := te.NewGELFSink(network, r.addr, te.GELFOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gelf.Close()
+		if err := tetest.TestSink(gelf.Send, r.messages); err != nil {
+			t.Errorf("GELF over %s:\n%v", network, err)
+		}
+	}
+}
+
+// receiver is a local syslog or GELF server recording the messages it
+// receives.
+type receiver struct {
+	addr   string
+	decode func(packet []byte) (string, bool)
+
+	mu     sync.Mutex
+	msgs   []string
+	chunks map[string][][]byte // GELF chunks by message id
+}
+
+func (r *receiver) messages() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.msgs...)
+}
+
+func (r *receiver) receive(packet []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if bytes.HasPrefix(packet, gelfChunkMagic) {
+		if packet = r.reassemble(packet); packet == nil {
+			return
+		}
+	}
+	if msg, ok := r.decode(packet); ok {
+		r.msgs = append(r.msgs, msg)
+	}
+}
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// reassemble records a GELF chunk and returns the whole payload once all
+// chunks of its message have arrived.
+func (r *receiver) reassemble(chunk []byte) []byte {
+	if len(chunk) < 12 {
+		return nil
+	}
+	id, seq, count := string(chunk[2:10]), int(chunk[10]), int(chunk[11])
+	if r.chunks == nil {
+		r.chunks = make(map[string][][]byte)
+	}
+	parts := r.chunks[id]
+	if parts == nil {
+		parts = make([][]byte, count)
+		r.chunks[id] = parts
+	}
+	if seq >= len(parts) {
+		return nil
+	}
+	parts[seq] = append([]byte(nil), chunk[12:]...)
+	for _, part := range parts {
+		if part == nil {
+			return nil
+		}
+	}
+	delete(r.chunks, id)
+	return bytes.Join(parts, nil)
+}
+
+// decodeSyslog returns the RFC 5424 message itself.
+func decodeSyslog(packet []byte) (string, bool) {
+	return string(packet), true
+}
+
+// decodeGELF returns the short and the full message of a GELF document,
+// gunzipping it first if needed.
+func decodeGELF(packet []byte) (string, bool) {
+	if bytes.HasPrefix(packet, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(packet))
+		if err != nil {
+			return "", false
+		}
+		if packet, err = io.ReadAll(zr); err != nil {
+			return "", false
+		}
+	}
+	var doc struct {
+		Short string `json:"short_message"`
+		Full  string `json:"full_message"`
+	}
+	if err := json.Unmarshal(packet, &doc); err != nil {
+		return "", false
+	}
+	return doc.Short + "\n" + doc.Full, true
+}
+
+// listen starts a receiver on a local address. Over TCP it splits the
+// stream into syslog octet-counted frames or GELF null-terminated frames.
+func listen(t *testing.T, network string, decode func([]byte) (string, bool)) *receiver {
+	t.Helper()
+	r := &receiver{decode: decode}
+	if network == "udp" {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pc.Close() })
+		go func() {
+			buf := make([]byte, 1<<16)
+			for {
+				n, _, err := pc.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				r.receive(append([]byte(nil), buf[:n]...))
+			}
+		}()
+		r.addr = pc.LocalAddr().String()
+		return r
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					frame, err := readFrame(br)
+					if err != nil {
+						return
+					}
+					r.receive(frame)
+				}
+			}()
+		}
+	}()
+	r.addr = ln.Addr().String()
+	return r
+}
+
+// readFrame reads a GELF frame, ended by a null byte, or a syslog frame,
+// prefixed by its length in octets.
+func readFrame(br *bufio.Reader) ([]byte, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == '{' {
+		frame, err := br.ReadBytes(0)
+		if err != nil {
+			return nil, err
+		}
+		return frame[:len(frame)-1], nil
+	}
+	prefix, err := br.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, n)
+	_, err = io.ReadFull(br, frame)
+	return frame, err
+}
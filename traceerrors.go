@@ -3,6 +3,7 @@ package trace_errors
 import (
 	"fmt"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -10,51 +11,63 @@ const includeStackInError = true
 
 // TraceError wraps an error with a message and a stack frame.
 type TraceError struct {
-	Msg   string
-	Err   error
-	Frame string
+	Msg    string
+	Err    error
+	Frame  string
+	Fields map[string]interface{}
+
+	// Runtime is set when runtime snapshots are on, see SetRuntimeSnapshots.
+	Runtime *RuntimeSnapshot
+
+	// Enqueued is set on errors wrapped by Provenance.Wrap.
+	Enqueued *Provenance
+
+	stack    *stack
+	template string // message before formatting, see SiteCode
+	site     string // site code restored by UnmarshalChain
 }
 
-// Error implements the error interface.
+// Error implements the error interface using the default renderer. If the
+// renderer fails, the text renderer is used instead, and an error that
+// renders as nothing at all is described by its type.
 func (e *TraceError) Error() string {
 	var b strings.Builder
-	if e.Msg != "" {
-		b.WriteString(e.Msg)
-		if e.Err != nil {
-			b.WriteString(": ")
-		}
+	if err := DefaultRenderer().Render(&b, e, RenderOptions{Stack: includeStackInError}); err != nil || b.Len() == 0 {
+		b.Reset()
+		_ = renderText(&b, e, RenderOptions{Stack: includeStackInError})
 	}
-	if e.Err != nil {
-		b.WriteString(e.Err.Error())
+	if b.Len() == 0 {
+		return typeName(e)
 	}
-
-	if includeStackInError && e.Frame != "" {
-		b.WriteString("\n")
-		b.WriteString(StackTrace(e))
-	}
-
-	return b.String()
+	// Renderers writing one record per line end it with a newline, which
+	// does not belong in an error message.
+	return strings.TrimSuffix(b.String(), "\n")
 }
 
-// Unwrap returns the underlying error.
+// Unwrap returns the underlying error, or nil if e is nil.
 func (e *TraceError) Unwrap() error {
+	if e == nil {
+		return nil
+	}
 	return e.Err
 }
 
 // New creates a new TraceError with a message and a stack frame.
 func New(msg string) error {
-	return &TraceError{
-		Msg:   msg,
-		Frame: captureStackFrame(),
-	}
+	return created(&TraceError{
+		Msg:      msg,
+		Frame:    captureStackFrame(),
+		template: msg,
+	})
 }
 
 // Newf creates a new TraceError with a formatted message and a stack frame.
 func Newf(format string, args ...interface{}) error {
-	return &TraceError{
-		Msg:   fmt.Sprintf(format, args...),
-		Frame: captureStackFrame(),
-	}
+	return created(&TraceError{
+		Msg:      fmt.Sprintf(format, args...),
+		Frame:    captureStackFrame(),
+		template: format,
+	})
 }
 
 // Wrap wraps an existing error with a message and a stack frame.
@@ -62,11 +75,12 @@ func Wrap(err error, msg string) error {
 	if err == nil {
 		return nil
 	}
-	return &TraceError{
-		Msg:   msg,
-		Err:   err,
-		Frame: captureStackFrame(),
-	}
+	return created(&TraceError{
+		Msg:      msg,
+		Err:      err,
+		Frame:    captureStackFrame(),
+		template: msg,
+	})
 }
 
 // Wrapf wraps an existing error with a formatted message and a stack frame.
@@ -74,11 +88,12 @@ func Wrapf(err error, format string, args ...interface{}) error {
 	if err == nil {
 		return nil
 	}
-	return &TraceError{
-		Msg:   fmt.Sprintf(format, args...),
-		Err:   err,
-		Frame: captureStackFrame(),
-	}
+	return created(&TraceError{
+		Msg:      fmt.Sprintf(format, args...),
+		Err:      err,
+		Frame:    captureStackFrame(),
+		template: format,
+	})
 }
 
 // WrapTrace wraps an existing error with a stack frame.
@@ -86,10 +101,10 @@ func WrapTrace(err error) error {
 	if err == nil {
 		return nil
 	}
-	return &TraceError{
+	return created(&TraceError{
 		Err:   err,
 		Frame: captureStackFrame(),
-	}
+	})
 }
 
 // captureStackFrame captures the current stack frame.
@@ -103,21 +118,54 @@ func captureStackFrame() string {
 	if fn != nil {
 		function = fn.Name()
 	}
-	return fmt.Sprintf("%s\n\t%s:%d", function, file, line)
+	return function + "\n\t" + file + ":" + strconv.Itoa(line)
+}
+
+// FieldsOf returns the fields attached to every TraceError in the chain.
+// When the same key is set on several links, the outermost value wins.
+func FieldsOf(err error) map[string]interface{} {
+	var fields map[string]interface{}
+	for _, link := range chain(err) {
+		te, ok := link.(*TraceError)
+		if !ok {
+			break
+		}
+		for k, v := range te.Fields {
+			if fields == nil {
+				fields = make(map[string]interface{})
+			}
+			if _, exists := fields[k]; !exists {
+				fields[k] = v
+			}
+		}
+	}
+	return fields
 }
 
 // StackTrace returns the full stack trace by traversing the error chain.
+// When the chain ends in a RemoteError, its frames come first under a
+// header naming the remote service. Where jobs that failed were enqueued
+// comes last, under an "enqueued at:" header per job.
 func StackTrace(err error) string {
-	var frames []string
-	for err != nil {
-		if te, ok := err.(*TraceError); ok {
+	var frames, enqueued []string
+	for _, link := range chain(err) {
+		if te, ok := link.(*TraceError); ok {
 			if te.Frame != "" {
 				frames = append([]string{te.Frame}, frames...)
 			}
-			err = te.Err
+			if te.Enqueued != nil && te.Enqueued.Frame != "" {
+				enqueued = append([]string{"enqueued at:", te.Enqueued.Frame}, enqueued...)
+			}
 		} else {
+			if remote := findRemote(link); remote != nil {
+				section := []string{remote.header()}
+				for _, f := range remote.Frames {
+					section = append(section, f.String())
+				}
+				frames = append(section, frames...)
+			}
 			break
 		}
 	}
-	return strings.Join(frames, "\n")
+	return strings.Join(append(frames, enqueued...), "\n")
 }